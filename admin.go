@@ -0,0 +1,79 @@
+package kboot
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// AdminUnitName the name of the built-in admin unit, enabled by CfgKeyAdminAddr
+	AdminUnitName      = "kboot-admin"
+	AdminLivenessPath  = "/health/live"
+	AdminReadinessPath = "/health/ready"
+)
+
+// registerAdminUnit register the admin unit if CfgKeyAdminAddr is set
+func (this *_ctx) registerAdminUnit() {
+	addr := this.GetViper().GetString(CfgKeyAdminAddr)
+	if addr == "" {
+		return
+	}
+	if this.hasUnit(AdminUnitName) {
+		return
+	}
+	this.RegisterUnit(AdminUnitName, this.initAdmin, UseExitPolicy(ExitPolicyShutdownOnFailure))
+}
+
+func (this *_ctx) initAdmin(unit Unit) (ExecFunc, error) {
+	addr := this.GetViper().GetString(CfgKeyAdminAddr)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "admin listen on %s failed", addr)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminLivenessPath, func(w http.ResponseWriter, r *http.Request) {
+		report := this.Health(r.Context())
+		writeHealthReport(w, report.Live, report)
+	})
+	mux.HandleFunc(AdminReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		report := this.Health(r.Context())
+		writeHealthReport(w, report.Ready, report)
+	})
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return func(unit Unit) ExitResult {
+		this.logger.Info("admin serving", zap.String("addr", listener.Addr().String()))
+		errC := make(chan error, 1)
+		go func() {
+			errC <- srv.Serve(listener)
+		}()
+		unit.MarkReady()
+		select {
+		case err := <-errC:
+			return NewBadResult(err)
+		case <-unit.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(ctx)
+			return NewSuccessResult()
+		}
+	}, nil
+}
+
+func writeHealthReport(w http.ResponseWriter, ok bool, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
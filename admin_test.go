@@ -0,0 +1,90 @@
+package kboot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// testHealthReport the JSON body of the admin health endpoints
+type testHealthReport struct {
+	Live  bool `json:"live"`
+	Ready bool `json:"ready"`
+	Units []struct {
+		Name   string        `json:"name"`
+		State  string        `json:"state"`
+		Checks []CheckResult `json:"checks"`
+	} `json:"units"`
+}
+
+// freeTestAddr a local address no one listens on
+func freeTestAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestInstance_Admin(t *testing.T) {
+	inst := newTestInstance(t)
+	addr := freeTestAddr(t)
+	writeTestConfig(t, "application.yaml", fmt.Sprintf("kboot:\n  admin:\n    addr: %s\n", addr))
+	dbHealthy := atomic.Bool{}
+	inst.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		unit.RegisterHealthCheck("db", func(ctx context.Context) error {
+			if !dbHealthy.Load() {
+				return errors.New("db unreachable")
+			}
+			return nil
+		})
+		return func(unit Unit) ExitResult {
+			<-unit.Done()
+			return NewSuccessResult()
+		}, nil
+	})
+	get := func(path string) (int, testHealthReport) {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("unexpected content type %s", contentType)
+		}
+		report := testHealthReport{}
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, report
+	}
+	inst.OnStarted(func(ctx Context) error {
+		defer ctx.Shutdown(nil)
+		if code, report := get(AdminLivenessPath); code != http.StatusOK || !report.Live {
+			t.Errorf("expect live 200, got %d %+v", code, report)
+		}
+		code, report := get(AdminReadinessPath)
+		if code != http.StatusServiceUnavailable || report.Ready {
+			t.Errorf("expect ready 503, got %d %+v", code, report)
+		}
+		if len(report.Units) != 2 || report.Units[0].Name != "api" || report.Units[0].State != "running" ||
+			len(report.Units[0].Checks) != 1 || report.Units[0].Checks[0].Error != "db unreachable" {
+			t.Errorf("unexpected units %+v", report.Units)
+		}
+		dbHealthy.Store(true)
+		if code, report := get(AdminReadinessPath); code != http.StatusOK || !report.Ready {
+			t.Errorf("expect ready 200, got %d %+v", code, report)
+		}
+		return nil
+	})
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+package kboot
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// check validate the config and the unit graph without calling any InitFunc or ExecFunc,
+// print the report to stdout and return an error if any problem is found
+func (this *_ctx) check() error {
+	problems := 0
+	buf := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(buf, "kboot check report of %s\n", this.GetAppName())
+	_, _ = fmt.Fprintf(buf, "  profiles: %s\n", strings.Join(this.GetActivatedProfiles(), ", "))
+	graph, graphErr := this.resolveUnits()
+	for _, u := range this.units {
+		if reason, disabled := this.disabledUnits[u.GetName()]; disabled {
+			_, _ = fmt.Fprintf(buf, "  [skip] unit %s: disabled, %s\n", u.GetName(), reason)
+			continue
+		}
+		if u.config == nil {
+			_, _ = fmt.Fprintf(buf, "  [ok]   unit %s\n", u.GetName())
+			continue
+		}
+		if _, err := u.config.load(this.GetViper()); err != nil {
+			problems++
+			_, _ = fmt.Fprintf(buf, "  [fail] unit %s: %v\n", u.GetName(), err)
+			continue
+		}
+		_, _ = fmt.Fprintf(buf, "  [ok]   unit %s: config [%s]\n", u.GetName(), u.config.key)
+	}
+	if graphErr != nil {
+		problems++
+		_, _ = fmt.Fprintf(buf, "  [fail] graph: %v\n", graphErr)
+	} else {
+		_, _ = fmt.Fprintf(buf, "  [ok]   graph: init order %s\n", strings.Join(graph.names(), ", "))
+	}
+	if problems == 0 {
+		buf.WriteString("check passed\n")
+	} else {
+		_, _ = fmt.Fprintf(buf, "check failed: %d problem(s)\n", problems)
+	}
+	if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if problems != 0 {
+		return errors.Errorf("check failed: %d problem(s)", problems)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package kboot
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// unitCondition decide whether the unit is enabled, return the reason if not
+type unitCondition func(ctx *_ctx) (bool, string)
+
+// OnProfile enable the unit only if one of the profiles is activated
+func OnProfile(profiles ...string) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		unit.conditions = append(unit.conditions, func(ctx *_ctx) (bool, string) {
+			for _, p := range profiles {
+				if ctx.isProfileActive(p) {
+					return true, ""
+				}
+			}
+			return false, fmt.Sprintf("none of profiles %v is activated", profiles)
+		})
+	})
+}
+
+// ConditionalOnProperty enable the unit only if the config key equals to value (case-insensitive),
+// an empty value matches any value except 'false'
+func ConditionalOnProperty(key, value string) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		unit.conditions = append(unit.conditions, func(ctx *_ctx) (bool, string) {
+			v := ctx.GetViper()
+			if !v.IsSet(key) {
+				return false, fmt.Sprintf("property '%s' is not set", key)
+			}
+			actual := v.GetString(key)
+			if value == "" {
+				if strings.EqualFold(actual, "false") {
+					return false, fmt.Sprintf("property '%s' is false", key)
+				}
+				return true, ""
+			}
+			if !strings.EqualFold(actual, value) {
+				return false, fmt.Sprintf("property '%s' is '%s', expect '%s'", key, actual, value)
+			}
+			return true, ""
+		})
+	})
+}
+
+// ConditionalOnMissingUnit enable the unit only if no enabled unit named name is registered,
+// evaluated after all other conditions
+func ConditionalOnMissingUnit(name string) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		unit.missingUnits = append(unit.missingUnits, name)
+	})
+}
+
+// resolveEnabledUnits evaluate the config switches and conditions of units,
+// return the enabled units in registration order and record the disabled ones
+func (this *_ctx) resolveEnabledUnits() []*unitImpl {
+	this.disabledUnits = make(map[string]string)
+	disabledByConfig := this.configDisabledUnits()
+	enabled := make([]*unitImpl, 0, len(this.units))
+	for _, u := range this.units {
+		if reason, ok := disabledByConfig[u.GetName()]; ok {
+			this.disableUnit(u, reason)
+			continue
+		}
+		if ok, reason := u.evaluateConditions(this); !ok {
+			this.disableUnit(u, reason)
+			continue
+		}
+		enabled = append(enabled, u)
+	}
+	// a missing unit condition is evaluated after the conditions of the unit it refers to,
+	// so the result does not depend on registration order, units in a cycle count as present
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*unitImpl]int, len(enabled))
+	var isEnabled func(u *unitImpl) bool
+	isEnabled = func(u *unitImpl) bool {
+		switch state[u] {
+		case visiting:
+			return true
+		case visited:
+			_, disabled := this.disabledUnits[u.GetName()]
+			return !disabled
+		}
+		state[u] = visiting
+		reason := ""
+		for _, missing := range u.missingUnits {
+			for _, other := range enabled {
+				if other != u && other.GetName() == missing && isEnabled(other) {
+					reason = fmt.Sprintf("unit '%s' is present", missing)
+					break
+				}
+			}
+			if reason != "" {
+				break
+			}
+		}
+		state[u] = visited
+		if reason != "" {
+			this.disableUnit(u, reason)
+		}
+		return reason == ""
+	}
+	ret := make([]*unitImpl, 0, len(enabled))
+	for _, u := range enabled {
+		if isEnabled(u) {
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
+
+// configDisabledUnits the units disabled by CfgKeyUnitsDisabled or CfgKeyUnitEnabled
+func (this *_ctx) configDisabledUnits() map[string]string {
+	ret := make(map[string]string)
+	v := this.GetViper()
+	for _, item := range v.GetStringSlice(CfgKeyUnitsDisabled) {
+		for _, name := range strings.Split(item, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				ret[name] = fmt.Sprintf("listed in '%s'", CfgKeyUnitsDisabled)
+			}
+		}
+	}
+	for name := range ret {
+		if !this.hasUnit(name) {
+			this.logger.Warn("unknown unit to disable", zap.String("unit", name), zap.String("key", CfgKeyUnitsDisabled))
+		}
+	}
+	for _, u := range this.units {
+		key := fmt.Sprintf(CfgKeyUnitEnabled, u.GetName())
+		if v.IsSet(key) && !v.GetBool(key) {
+			ret[u.GetName()] = fmt.Sprintf("'%s' is false", key)
+		}
+	}
+	return ret
+}
+
+func (this *_ctx) hasUnit(name string) bool {
+	for _, u := range this.units {
+		if u.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *_ctx) disableUnit(unit *unitImpl, reason string) {
+	this.disabledUnits[unit.GetName()] = reason
+	this.logger.Info("unit disabled", zap.String("unit", unit.GetName()), zap.String("reason", reason))
+}
+
+// isProfileActive whether the profile is activated, case-insensitive
+func (this *_ctx) isProfileActive(profile string) bool {
+	for _, p := range this.GetActivatedProfiles() {
+		if strings.EqualFold(p, profile) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *unitImpl) evaluateConditions(ctx *_ctx) (bool, string) {
+	for _, cond := range this.conditions {
+		if ok, reason := cond(ctx); !ok {
+			return false, reason
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,56 @@
+package kboot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveEnabledUnits_MissingUnit(t *testing.T) {
+	options := map[string][]UnitOption{
+		"local": {ConditionalOnMissingUnit("redis")},
+		"redis": {ConditionalOnMissingUnit("fake")},
+		"fake":  nil,
+	}
+	cases := []struct {
+		order []string
+		want  string
+	}{
+		{[]string{"local", "redis", "fake"}, "local,fake"},
+		{[]string{"fake", "redis", "local"}, "fake,local"},
+	}
+	for _, c := range cases {
+		ctx := newTestExecContext()
+		for _, name := range c.order {
+			ctx.RegisterUnit(name, func(unit Unit) (ExecFunc, error) {
+				return nil, nil
+			}, options[name]...)
+		}
+		enabled := make([]string, 0)
+		for _, u := range ctx.resolveEnabledUnits() {
+			enabled = append(enabled, u.GetName())
+		}
+		if got := strings.Join(enabled, ","); got != c.want {
+			t.Fatalf("registered %v, expect %s enabled, got %s", c.order, c.want, got)
+		}
+		if reason := ctx.disabledUnits["redis"]; reason != "unit 'fake' is present" {
+			t.Fatalf("unexpected disabled reason %s", reason)
+		}
+	}
+}
+
+func TestResolveEnabledUnits_Profile(t *testing.T) {
+	ctx := newTestExecContext()
+	ctx.profiles = []string{"base", "prod", "proddb"}
+	initFn := func(unit Unit) (ExecFunc, error) {
+		return nil, nil
+	}
+	ctx.RegisterUnit("db", initFn, OnProfile("ProdDB"))
+	ctx.RegisterUnit("mock", initFn, OnProfile("dev", "test"))
+	enabled := ctx.resolveEnabledUnits()
+	if len(enabled) != 1 || enabled[0].GetName() != "db" {
+		t.Fatalf("expect only db enabled, got %d units", len(enabled))
+	}
+	if reason := ctx.disabledUnits["mock"]; reason != "none of profiles [dev test] is activated" {
+		t.Fatalf("unexpected disabled reason %s", reason)
+	}
+}
@@ -0,0 +1,303 @@
+package kboot
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// ConfigSourceKind the kind of a config source
+type ConfigSourceKind string
+
+const (
+	// ConfigSourceDefault the defaults of kboot
+	ConfigSourceDefault ConfigSourceKind = "default"
+	// ConfigSourceBytes the config set by ConfigFromBytes
+	ConfigSourceBytes ConfigSourceKind = "bytes"
+	// ConfigSourceFile a config file
+	ConfigSourceFile ConfigSourceKind = "file"
+	// ConfigSourceEnv the system env, see ConfigEnvOverride
+	ConfigSourceEnv ConfigSourceKind = "env"
+	// ConfigSourceFlag the command line flags, see CommandLine
+	ConfigSourceFlag ConfigSourceKind = "flag"
+)
+
+// ConfigSource a layer of the merged config
+type ConfigSource struct {
+	Kind ConfigSourceKind
+	// Name the file path of ConfigSourceFile, the prefix of ConfigSourceEnv
+	// or the flag set name of ConfigSourceFlag
+	Name string
+	// Profile the profile of a profile config file
+	Profile string
+}
+
+func (this ConfigSource) String() string {
+	if this.Name == "" {
+		return string(this.Kind)
+	}
+	return string(this.Kind) + ":" + this.Name
+}
+
+// loadedConfig the result of merging all config layers
+type loadedConfig struct {
+	viper *viper.Viper
+	// sources the merged config sources in merge order
+	sources []ConfigSource
+	// profiles the expanded active profiles
+	profiles []string
+	// origins the source which last set each key
+	origins map[string]ConfigSource
+	// templates the values of the keys with placeholders before they are resolved
+	templates map[string]interface{}
+}
+
+// setOrigin record the source of the keys
+func (this *loadedConfig) setOrigin(source ConfigSource, keys ...string) {
+	for _, key := range keys {
+		this.origins[key] = source
+	}
+}
+
+// files the merged config files in merge order
+func (this *loadedConfig) files() []string {
+	ret := make([]string, 0, len(this.sources))
+	for _, source := range this.sources {
+		if source.Kind == ConfigSourceFile {
+			ret = append(ret, source.Name)
+		}
+	}
+	return ret
+}
+
+// useConfig swap in the loaded config
+func (this *_ctx) useConfig(loaded *loadedConfig) {
+	this.viperLock.Lock()
+	defer this.viperLock.Unlock()
+	this.viper = loaded.viper
+	this.configSources = loaded.sources
+	this.profiles = loaded.profiles
+	this.configOrigins = loaded.origins
+	this.configTemplates = loaded.templates
+}
+
+func (this *_ctx) GetConfigSources() []ConfigSource {
+	this.viperLock.RLock()
+	defer this.viperLock.RUnlock()
+	return append([]ConfigSource{}, this.configSources...)
+}
+
+// loadConfig merge all config layers into v, later layers take precedence:
+//   - the defaults
+//   - the bytes of ConfigFromBytes
+//   - the file of ConfigFromFile
+//   - the non-profile config files, search path by search path,
+//     the main config (DefaultConfigName) first then the others by name
+//   - the profile config files, profile by profile in activation order,
+//     then search path by search path and name by name,
+//     profiles included or grouped by profile files are activated after the ones already applied
+//   - the system env
+//   - the command line flags
+//
+// placeholders in values are resolved after all layers are merged, see placeholderResolver
+func (this *_ctx) loadConfig(v *viper.Viper) (*loadedConfig, error) {
+	loaded := &loadedConfig{
+		viper:     v,
+		sources:   []ConfigSource{{Kind: ConfigSourceDefault}},
+		origins:   make(map[string]ConfigSource),
+		templates: make(map[string]interface{}),
+	}
+	loaded.setOrigin(ConfigSource{Kind: ConfigSourceDefault}, v.AllKeys()...)
+	// bind env and flags before reading any profile key, so they can activate profiles,
+	// viper still gives them precedence over every config file
+	if err := this.bindOverrides(v); err != nil {
+		return nil, err
+	}
+	if len(this.configData) > 0 {
+		dataType := this.configFileType
+		if dataType == "" {
+			dataType = "yaml"
+		}
+		layer := viper.New()
+		layer.SetConfigType(dataType)
+		if err := layer.ReadConfig(bytes.NewReader(this.configData)); err != nil {
+			return nil, errors.Wrap(err, "load config from bytes error")
+		}
+		if err := this.mergeLayer(loaded, layer, ConfigSource{Kind: ConfigSourceBytes}); err != nil {
+			return nil, err
+		}
+	}
+	if this.configFile != "" {
+		if err := this.mergeConfigFile(loaded, this.configFile, ""); err != nil {
+			return nil, err
+		}
+	}
+	finder := newConfigFinder(this.logger)
+	found := make([][]*configItem, 0, len(this.configSearchPaths))
+	for _, dir := range this.configSearchPaths {
+		configList, err := finder.FindConfigs(dir, this.configExts()...)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, this.sortConfigItems(configList))
+	}
+	for _, items := range found {
+		for _, item := range items {
+			if item.Default == nil || !this.isDefaultConfigMerged(item) {
+				continue
+			}
+			if err := this.mergeConfigFile(loaded, item.Default.FilePath, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// profile files may include or group more profiles, expand again until no new profile shows up
+	loaded.profiles = make([]string, 0)
+	for {
+		added := newProfiles(loaded.profiles, resolveProfiles(v))
+		if len(added) == 0 {
+			break
+		}
+		for _, profile := range added {
+			matched := false
+			for _, items := range found {
+				for _, item := range items {
+					for _, cfgFile := range item.Profiles {
+						if strings.EqualFold(cfgFile.Profile, profile) {
+							matched = true
+							if err := this.mergeConfigFile(loaded, cfgFile.FilePath, profile); err != nil {
+								return nil, err
+							}
+						}
+					}
+				}
+			}
+			if !matched {
+				this.logger.Warn("no config file found for active profile", zap.String("profile", profile))
+			}
+			loaded.profiles = append(loaded.profiles, profile)
+		}
+	}
+	this.logger.Info("active profiles", zap.Strings("profiles", loaded.profiles))
+	// flags not set on the command line are defaults
+	this.flagSet.VisitAll(func(flag *pflag.Flag) {
+		if _, ok := loaded.origins[strings.ToLower(flag.Name)]; !ok {
+			loaded.setOrigin(ConfigSource{Kind: ConfigSourceDefault}, strings.ToLower(flag.Name))
+		}
+	})
+	if this.enableEnvOverride {
+		loaded.sources = append(loaded.sources, ConfigSource{Kind: ConfigSourceEnv, Name: this.envPrefix})
+		for _, key := range v.AllKeys() {
+			name := this.envName(key)
+			if val, ok := os.LookupEnv(name); ok && val != "" {
+				loaded.setOrigin(ConfigSource{Kind: ConfigSourceEnv, Name: name}, key)
+			}
+		}
+	}
+	loaded.sources = append(loaded.sources, ConfigSource{Kind: ConfigSourceFlag, Name: this.flagSet.Name()})
+	this.flagSet.Visit(func(flag *pflag.Flag) {
+		loaded.setOrigin(ConfigSource{Kind: ConfigSourceFlag, Name: "--" + flag.Name}, strings.ToLower(flag.Name))
+	})
+	if err := interpolateConfig(loaded); err != nil {
+		return nil, errors.Wrap(err, "resolve config placeholders failed")
+	}
+	return loaded, nil
+}
+
+// bindOverrides bind the system env and the command line flags to v
+func (this *_ctx) bindOverrides(v *viper.Viper) error {
+	if this.enableEnvOverride {
+		v.AutomaticEnv()
+		if this.envPrefix != "" {
+			v.SetEnvPrefix(this.envPrefix)
+		}
+		v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	}
+	if err := v.BindPFlags(this.flagSet); err != nil {
+		return errors.Wrap(err, "bind command line flags failed")
+	}
+	return nil
+}
+
+// envName the env var overriding the key
+func (this *_ctx) envName(key string) string {
+	name := strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+	if this.envPrefix != "" {
+		name = strings.ToUpper(this.envPrefix) + "_" + name
+	}
+	return name
+}
+
+// mergeConfigFile merge the file into the loaded config
+func (this *_ctx) mergeConfigFile(loaded *loadedConfig, file, profile string) error {
+	this.logger.Info("apply config ", zap.String("file", file), zap.String("profile", profile))
+	layer := viper.New()
+	layer.SetConfigFile(file)
+	layer.SetConfigType(this.configFileType)
+	if err := layer.ReadInConfig(); err != nil {
+		return errors.Wrapf(err, "load config %s error", file)
+	}
+	return this.mergeLayer(loaded, layer, ConfigSource{Kind: ConfigSourceFile, Name: file, Profile: profile})
+}
+
+// mergeLayer merge the config read by layer into the loaded config and record the source of its keys
+func (this *_ctx) mergeLayer(loaded *loadedConfig, layer *viper.Viper, source ConfigSource) error {
+	if err := loaded.viper.MergeConfigMap(layer.AllSettings()); err != nil {
+		return errors.Wrapf(err, "merge config %s error", source)
+	}
+	loaded.sources = append(loaded.sources, source)
+	loaded.setOrigin(source, layer.AllKeys()...)
+	return nil
+}
+
+// sortConfigItems the main config first, then the others by name
+func (this *_ctx) sortConfigItems(configList map[string]*configItem) []*configItem {
+	items := make([]*configItem, 0, len(configList))
+	for _, item := range configList {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		iMain, jMain := items[i].Name == this.configName, items[j].Name == this.configName
+		if iMain != jMain {
+			return iMain
+		}
+		return items[i].Name < items[j].Name
+	})
+	return items
+}
+
+// isDefaultConfigMerged whether the non-profile file of the config is merged,
+// the DefaultConfigName one is only merged as the main config
+func (this *_ctx) isDefaultConfigMerged(item *configItem) bool {
+	if this.configFile != "" && samePath(item.Default.FilePath, this.configFile) {
+		return false
+	}
+	if item.Name == this.configName {
+		return this.configFile == ""
+	}
+	return item.Name != DefaultConfigName
+}
+
+// configExts the extensions of config files to look for
+func (this *_ctx) configExts() []string {
+	if this.configFileType != "" {
+		return []string{this.configFileType}
+	}
+	return viper.SupportedExts
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
@@ -0,0 +1,64 @@
+package kboot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// secretKeyWords keys containing any of them have their values masked in reports
+var secretKeyWords = []string{"password", "passwd", "pwd", "secret", "token", "credential", "private", "apikey", "api-key", "api_key"}
+
+const maskedValue = "******"
+
+func (this *_ctx) ConfigOrigin(key string) (ConfigSource, bool) {
+	this.viperLock.RLock()
+	defer this.viperLock.RUnlock()
+	source, ok := this.configOrigins[strings.ToLower(key)]
+	return source, ok
+}
+
+// printConfigOrigins write every effective config key with its value and origin to w,
+// values of secret keys are masked and values with placeholders are shown unresolved,
+// so secrets they reference are not revealed
+func (this *_ctx) printConfigOrigins(w io.Writer) error {
+	v := this.GetViper()
+	this.viperLock.RLock()
+	templates := this.configTemplates
+	this.viperLock.RUnlock()
+	keys := v.AllKeys()
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "KEY\tVALUE\tKIND\tORIGIN")
+	for _, key := range keys {
+		value := fmt.Sprint(v.Get(key))
+		if template, ok := templates[key]; ok {
+			value = fmt.Sprint(template)
+		}
+		if isSecretKey(key) {
+			value = maskedValue
+		}
+		kind, origin := "unknown", ""
+		if source, ok := this.ConfigOrigin(key); ok {
+			kind, origin = string(source.Kind), source.Name
+			if source.Profile != "" {
+				origin = fmt.Sprintf("%s (profile %s)", origin, source.Profile)
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key, value, kind, origin)
+	}
+	return tw.Flush()
+}
+
+// isSecretKey whether the value of the key should be masked
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range secretKeyWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package kboot
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ooopSnake/assert.go"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultConfigReloadDelay the quiet period after the last config file change before reloading
+const DefaultConfigReloadDelay = 200 * time.Millisecond
+
+// ConfigChangeFunc called after a config reload changed the value of the key,
+// old or new is nil if the key is absent
+type ConfigChangeFunc func(old, new interface{})
+
+// configWatcher a subscription to the changes of a config key
+type configWatcher struct {
+	key string
+	// decode read the value of the key from v, an error rejects the reload
+	decode func(v *viper.Viper) (interface{}, error)
+	fn     ConfigChangeFunc
+}
+
+// OnConfigChange subscribe the changes of the key on the default instance, see Instance.OnConfigChange
+func OnConfigChange(key string, fn ConfigChangeFunc) {
+	_gCtx.OnConfigChange(key, fn)
+}
+
+// Watch subscribe the changes of the typed config of the key on the default instance,
+// a reload is rejected if the new config of the key can't be parsed or validated
+func Watch[T any](key string, fn func(old, new *T)) {
+	WatchTo[T](_gCtx, key, fn)
+}
+
+// WatchTo same as Watch but subscribe on special instance
+func WatchTo[T any](inst Instance, key string, fn func(old, new *T)) {
+	assert.Must(fn != nil, "watch func must not be nil").Panic()
+	ctx, ok := inst.(*_ctx)
+	assert.Must(ok, "unsupported instance").Panic()
+	ctx.addConfigWatcher(&configWatcher{
+		key: key,
+		decode: func(v *viper.Viper) (interface{}, error) {
+			return loadTypedConfig[T](v, key)
+		},
+		fn: func(old, new interface{}) {
+			fn(old.(*T), new.(*T))
+		},
+	})
+}
+
+func (this *_ctx) OnConfigChange(key string, fn ConfigChangeFunc) {
+	assert.Must(fn != nil, "config change func must not be nil").Panic()
+	this.addConfigWatcher(&configWatcher{
+		key: key,
+		decode: func(v *viper.Viper) (interface{}, error) {
+			return v.Get(key), nil
+		},
+		fn: fn,
+	})
+}
+
+func (this *_ctx) addConfigWatcher(w *configWatcher) {
+	this.configWatchLock.Lock()
+	defer this.configWatchLock.Unlock()
+	this.configWatchers = append(this.configWatchers, w)
+}
+
+// reloadConfig merge all config layers into a fresh viper, validate it and swap it in,
+// then notify the subscribers of changed keys, the current config is kept on any error
+func (this *_ctx) reloadConfig() error {
+	v := newViper()
+	loaded, err := this.loadConfig(v)
+	if err != nil {
+		return err
+	}
+	if lv := v.GetString(CfgKeyAppLogLevel); lv != "" {
+		if _, err := zapcore.ParseLevel(lv); err != nil {
+			return errors.Errorf("invalid app.log.level %s", lv)
+		}
+	}
+	for _, u := range this.enabledUnits() {
+		if u.config == nil {
+			continue
+		}
+		if _, err := u.config.load(v); err != nil {
+			return errors.Wrapf(err, "unit '%s'", u.GetName())
+		}
+	}
+	// subscribers may subscribe again when notified, so the lock is not held meanwhile
+	this.configWatchLock.Lock()
+	watchers := append([]*configWatcher{}, this.configWatchers...)
+	this.configWatchLock.Unlock()
+	oldV := this.GetViper()
+	type change struct {
+		watcher  *configWatcher
+		old, new interface{}
+	}
+	changes := make([]change, 0)
+	for _, w := range watchers {
+		newVal, err := w.decode(v)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(oldV.Get(w.key), v.Get(w.key)) {
+			continue
+		}
+		oldVal, err := w.decode(oldV)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{watcher: w, old: oldVal, new: newVal})
+	}
+	this.useConfig(loaded)
+	this.logger.Info("config reloaded", zap.Strings("files", loaded.files()), zap.Int("changes", len(changes)))
+	for _, c := range changes {
+		this.notifyConfigChange(c.watcher, c.old, c.new)
+	}
+	return nil
+}
+
+// notifyConfigChange call the subscriber, a panic is logged rather than crashing the watcher
+func (this *_ctx) notifyConfigChange(w *configWatcher, old, new interface{}) {
+	defer func() {
+		if exitPanic := recover(); exitPanic != nil {
+			this.logger.Error("config change func panic", zap.String("key", w.key), zap.Any("error", exitPanic))
+		}
+	}()
+	w.fn(old, new)
+}
+
+// watchConfigDirs the directories holding config files, which may be changed, added or removed
+func (this *_ctx) watchConfigDirs() []string {
+	this.viperLock.RLock()
+	defer this.viperLock.RUnlock()
+	dirs := make([]string, 0)
+	add := func(dir string) {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, dir := range this.configSearchPaths {
+		add(dir)
+	}
+	if this.configFile != "" {
+		add(filepath.Dir(this.configFile))
+	}
+	for _, source := range this.configSources {
+		if source.Kind == ConfigSourceFile {
+			add(filepath.Dir(source.Name))
+		}
+	}
+	return dirs
+}
+
+// isConfigEvent whether the file event may change the merged config
+func (this *_ctx) isConfigEvent(event fsnotify.Event) bool {
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) &&
+		!event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+		return false
+	}
+	ext := strings.TrimPrefix(filepath.Ext(event.Name), ".")
+	return slices.Contains(this.configExts(), ext)
+}
+
+// watchConfig reload the config on changes of config files, return the func to stop watching
+func (this *_ctx) watchConfig() (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "create config watcher failed")
+	}
+	watched := make(map[string]bool)
+	watchDirs := func() {
+		for _, dir := range this.watchConfigDirs() {
+			if watched[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				this.logger.Warn("watch config dir failed", zap.String("dir", dir), zap.Error(err))
+				continue
+			}
+			watched[dir] = true
+		}
+	}
+	watchDirs()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		timer := time.NewTimer(DefaultConfigReloadDelay)
+		timer.Stop()
+		defer timer.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if this.isConfigEvent(event) {
+					timer.Reset(DefaultConfigReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				this.logger.Warn("config watcher error", zap.Error(err))
+			case <-timer.C:
+				if err := this.reloadConfig(); err != nil {
+					this.logger.Error("config reload rejected, keep the current config", zap.Error(err))
+					continue
+				}
+				watchDirs()
+			}
+		}
+	}()
+	this.logger.Info("watching config changes", zap.Strings("dirs", this.watchConfigDirs()))
+	return func() {
+		close(stop)
+		<-stopped
+		_ = watcher.Close()
+	}, nil
+}
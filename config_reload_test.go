@@ -0,0 +1,37 @@
+package kboot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReloadConfig_SubscribeOnChange(t *testing.T) {
+	ctx := newTestInstance(t).(*_ctx)
+	writeTestConfig(t, "application.yaml", "limit:\n  rate: 1\n")
+	loaded, err := ctx.loadConfig(newViper())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.useConfig(loaded)
+	changes := make(chan interface{}, 1)
+	ctx.OnConfigChange("limit.rate", func(old, new interface{}) {
+		ctx.OnConfigChange("limit.burst", func(old, new interface{}) {})
+		changes <- new
+	})
+	writeTestConfig(t, "application.yaml", "limit:\n  rate: 2\n")
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- ctx.reloadConfig()
+	}()
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload blocked by the subscriber")
+	}
+	if rate := <-changes; rate != 2 {
+		t.Fatalf("expect rate 2, got %v", rate)
+	}
+}
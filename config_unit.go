@@ -0,0 +1,72 @@
+package kboot
+
+import (
+	"github.com/ooopSnake/assert.go"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// ConfiguredInitFunc the init func of a unit with its typed config
+type ConfiguredInitFunc[T any] func(unit Unit, cfg *T) (ExecFunc, error)
+
+// unitConfig the typed config bound to a unit
+type unitConfig struct {
+	key  string
+	load func(v *viper.Viper) (interface{}, error)
+}
+
+// RegisterConfiguredUnit register a unit to the default instance,
+// the sub config of key is unmarshalled into T and validated by MValidator before fn is called
+func RegisterConfiguredUnit[T any](name, key string, fn ConfiguredInitFunc[T], options ...UnitOption) {
+	RegisterConfiguredUnitTo[T](_gCtx, name, key, fn, options...)
+}
+
+// RegisterConfiguredUnitTo same as RegisterConfiguredUnit but register to special instance
+func RegisterConfiguredUnitTo[T any](inst Instance, name, key string, fn ConfiguredInitFunc[T], options ...UnitOption) {
+	assert.Must(inst != nil, "instance must not be nil").Panic()
+	assert.Must(fn != nil, "init func must not be nil").Panic()
+	options = append([]UnitOption{withConfig[T](key)}, options...)
+	inst.RegisterUnit(name, func(unit Unit) (ExecFunc, error) {
+		cfg, ok := UnitConfig[T](unit)
+		if !ok {
+			return nil, errors.Errorf("config [%s] of unit '%s' not loaded", key, unit.GetName())
+		}
+		return fn(unit, cfg)
+	}, options...)
+}
+
+// UnitConfig get the typed config of a unit registered by RegisterConfiguredUnit
+func UnitConfig[T any](unit Unit) (*T, bool) {
+	impl, ok := unit.(*unitImpl)
+	if !ok || impl.configValue == nil {
+		return nil, false
+	}
+	cfg, ok := impl.configValue.(*T)
+	return cfg, ok
+}
+
+func withConfig[T any](key string) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		unit.config = &unitConfig{
+			key: key,
+			load: func(v *viper.Viper) (interface{}, error) {
+				return loadTypedConfig[T](v, key)
+			},
+		}
+	})
+}
+
+// loadTypedConfig unmarshal the sub config of key into T and validate it,
+// a missing key is validated as the zero value
+func loadTypedConfig[T any](v *viper.Viper, key string) (*T, error) {
+	cfg := new(T)
+	if subV := v.Sub(key); subV != nil {
+		if err := subV.Unmarshal(cfg); err != nil {
+			return nil, errors.Wrapf(err, "parse [%s] config failed", key)
+		}
+	}
+	if err := MValidator().Validate(cfg); err != nil {
+		return nil, errors.Wrapf(err, "invalid [%s] config", key)
+	}
+	return cfg, nil
+}
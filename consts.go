@@ -11,8 +11,24 @@ const (
 	DefaultConfigName      = "application"
 	DefaultConfigFilePath  = "./config"
 	DefaultConfigEnvPrefix = ""
+	DefaultUnitStopTimeout = 30 * time.Second
 
 	CfgKeyProfilesActive = "kboot.profiles.active"
-	CfgKeyAppTz          = "app.timezone"
-	CfgKeyAppLogLevel    = "app.log.level"
+	// CfgKeyProfilesInclude profiles always activated before the active ones,
+	// those included by a profile config file are activated after the profiles already applied
+	CfgKeyProfilesInclude = "kboot.profiles.include"
+	// CfgKeyProfilesGroup the profiles activated along with the profile %s
+	CfgKeyProfilesGroup = "kboot.profiles.group.%s"
+	CfgKeyAppTz         = "app.timezone"
+	CfgKeyAppLogLevel   = "app.log.level"
+	// CfgKeyUnitsInitTimeout the default init deadline of units, <= 0 means no deadline
+	CfgKeyUnitsInitTimeout = "kboot.units.init-timeout"
+	// CfgKeyUnitsStopTimeout the default stop deadline of units, <= 0 means wait forever
+	CfgKeyUnitsStopTimeout = "kboot.units.stop-timeout"
+	// CfgKeyUnitsDisabled the names of units to disable
+	CfgKeyUnitsDisabled = "kboot.units.disabled"
+	// CfgKeyUnitEnabled format of the key to enable or disable a unit by name, default is true
+	CfgKeyUnitEnabled = "kboot.units.%s.enabled"
+	// CfgKeyAdminAddr the listen address of the admin unit serving health endpoints, disabled if empty
+	CfgKeyAdminAddr = "kboot.admin.addr"
 )
@@ -1,18 +1,15 @@
 package kboot
 
 import (
-	"bytes"
-	"container/list"
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
-	"sort"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/guestin/log"
-	"github.com/guestin/mob/msync"
+	"github.com/ooopSnake/assert.go"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -28,25 +25,62 @@ type (
 		GetApplication() Application
 		// GetViper get the viper instance
 		GetViper() *viper.Viper
+		// GetActivatedProfile the active profiles joined by comma
 		GetActivatedProfile() string
+		// GetActivatedProfiles the active profiles in the order they are applied, later ones take precedence
+		GetActivatedProfiles() []string
+		// GetConfigSources the config sources in merge order, later ones take precedence
+		GetConfigSources() []ConfigSource
+		// ConfigOrigin the source which set the effective value of the key,
+		// Name is the file path, the env var or the flag of the source
+		ConfigOrigin(key string) (ConfigSource, bool)
 		GetRootLogger() *zap.Logger
 		GetTaggedZapLogger(tag string, opt ...log.Opt) log.ZapLog
 		GetTaggedLogger(tag string, opt ...log.Opt) log.ClassicLog
 		UnmarshalSubConfig(key string, i interface{}, options ...CfgOption) (err error)
+		// GetRestartCount the number of times the unit has been restarted by its restart policy
+		GetRestartCount(unit string) int
+		// Health compute the liveness and readiness of the application from unit states and health checks
+		Health(ctx context.Context) HealthReport
+		// GetUnitGraph the unit dependency graph resolved by Run, an error before it is resolved
+		GetUnitGraph() (*UnitGraph, error)
+		// ExportUnitGraph render the unit dependency graph in GraphFormatDOT or GraphFormatJSON
+		ExportUnitGraph(format string) ([]byte, error)
 		Shutdown(err error)
 	}
 )
 
 type _ctx struct {
 	Application
-	ctx               context.Context
-	cancel            context.CancelFunc
-	viper             *viper.Viper
-	logLevel          string
-	hideBanner        bool
-	rootLogger        *zap.Logger
-	logger            log.ZapLog
-	units             []*unitImpl
+	ctx       context.Context
+	cancel    context.CancelFunc
+	viper     *viper.Viper
+	viperLock sync.RWMutex
+	// configSources the config sources merged into viper, in merge order
+	configSources []ConfigSource
+	// configOrigins the source which last set each key
+	configOrigins map[string]ConfigSource
+	// configTemplates the values of the keys with placeholders before they are resolved
+	configTemplates map[string]interface{}
+	// profiles the expanded active profiles
+	profiles        []string
+	hotReload       bool
+	configWatchers  []*configWatcher
+	configWatchLock sync.Mutex
+	logLevel        string
+	hideBanner      bool
+	rootLogger      *zap.Logger
+	logger          log.ZapLog
+	units           []*unitImpl
+	disabledUnits   map[string]string
+	// graph the resolved unit graph, see resolveUnits
+	graph             *unitGraph
+	graphErr          error
+	graphLock         sync.Mutex
+	services          *serviceRegistry
+	hooks             map[lifecycleStage][]HookFunc
+	checkMode         bool
+	panicPolicy       PanicPolicy
 	configName        string
 	configFileType    string
 	configSearchPaths []string
@@ -54,6 +88,15 @@ type _ctx struct {
 	configData        []byte
 	enableEnvOverride bool
 	envPrefix         string
+	flagSet           *pflag.FlagSet
+	args              []string
+	initParallelism   int
+	waitDependsReady  bool
+	readyTimeout      time.Duration
+	shutdownOnFailure bool
+	exitCodeReducer   ExitCodeReducer
+	results           []UnitExitResult
+	resultsLock       sync.Mutex
 }
 
 func (this *_ctx) GetApplication() Application {
@@ -101,128 +144,133 @@ func (this *_ctx) Shutdown(err error) {
 	this.kill()
 }
 
+func (this *_ctx) GetRestartCount(unit string) int {
+	for _, u := range this.units {
+		if u.GetName() == unit {
+			return u.RestartCount()
+		}
+	}
+	return 0
+}
+
 func (this *_ctx) GetViper() *viper.Viper {
+	this.viperLock.RLock()
+	defer this.viperLock.RUnlock()
 	return this.viper
 }
 
-func (this *_ctx) GetActivatedProfile() string {
-	return this.viper.GetString(CfgKeyProfilesActive)
+func (this *_ctx) HideBanner() {
+	this.hideBanner = true
 }
 
-func (this *_ctx) bootStrap() {
+func (this *_ctx) RegisterUnit(name string, fn InitFunc, options ...UnitOption) {
+	assert.Must(len(strings.TrimSpace(name)) != 0, "name must not empty or blank").Panic()
+	assert.Must(fn != nil, "init func must not be nil").Panic()
+	assert.Must(!this.hasUnit(name), fmt.Sprintf("name '%s' already exist", name)).Panic()
+	unit := &unitImpl{
+		rootCtx:  this,
+		name:     name,
+		initFunc: fn,
+		ready:    make(chan struct{}),
+	}
+	for _, opt := range options {
+		opt.apply(unit)
+	}
+	this.units = append(this.units, unit)
+}
+
+func (this *_ctx) Run(ctx context.Context) error {
+	assert.Must(ctx != nil, "root ctx must not be nil").Panic()
+	assert.Must(this.Application != nil, "app must not be nil").Panic()
+	if !this.hideBanner {
+		fmt.Print(_BANNER)
+	}
+	this.logger.Info("Bootstrap ... ",
+		zap.String("app", this.GetAppName()),
+		zap.String("tz", this.GetTimezone().String()))
+	_ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	this.ctx = _ctx
+	this.cancel = cancel
+	return this.bootStrap()
+}
+
+// bootStrap boot the application,
+// return *ExitError if units exited with a non-zero code
+func (this *_ctx) bootStrap() error {
 	defer func() {
 		_ = this.rootLogger.Sync()
 	}()
 	err := this.autoConfig()
 	if err != nil {
-		this.logger.Fatal("auto config failed", zap.Error(err))
-		return
+		return errors.Wrap(err, "auto config failed")
 	}
 	err = this.reinitLoggerIfNeeded()
 	if err != nil {
-		this.logger.Fatal("reinit logger failed", zap.Error(err))
-		return
+		return errors.Wrap(err, "reinit logger failed")
 	}
-	this.execute()
-}
-
-func (this *_ctx) autoConfig() error {
-	this.logger.Info("Load config ...")
-	pflag.Parse()
-	err := viper.BindPFlags(pflag.CommandLine)
-	if err != nil {
-		return err
+	if format := this.flagString(FlagPrintGraph); format != "" {
+		return this.printUnitGraph(format)
 	}
-	// load raw bytes first
-	if len(this.configData) > 0 {
-		err := this.viper.ReadConfig(bytes.NewReader(this.configData))
-		if err != nil {
-			if !errors.Is(err, &viper.ConfigFileNotFoundError{}) {
-				return errors.Wrap(err, "load main config error")
-			}
-		}
+	if this.flagBool(FlagPrintConfigOrigin) {
+		return this.printConfigOrigins(os.Stdout)
 	}
-	this.prepareConfigPath()
-	// load main config from file
-	err = this.viper.MergeInConfig()
-	if err != nil {
-		if !errors.As(err, &viper.ConfigFileNotFoundError{}) {
-			return err
-		}
-		// Config file not found; ignore error
+	if this.checkMode || this.flagBool(FlagCheck) {
+		return this.check()
 	}
-	activeProfile := this.GetActivatedProfile()
-	this.logger.Info("active profile ", zap.Any("activeProfile", activeProfile))
-	exts := make([]string, 0)
-	if this.configFileType != "" {
-		exts = append(exts, this.configFileType)
-	} else {
-		exts = append(exts, viper.SupportedExts...)
+	if err := this.runHooks(stageConfigLoaded, true); err != nil {
+		return err
 	}
-	finder := newConfigFinder(this.logger)
-	// load other config
-	for _, dir := range this.configSearchPaths {
-		configList, err := finder.FindConfigs(dir, exts...)
-		if err != nil {
+	if this.hotReload {
+		// reloads validate the configs of the enabled units, resolve them before watching
+		if _, err := this.resolveUnits(); err != nil {
 			return err
 		}
-		for cfgName := range configList {
-			cfg := configList[cfgName]
-			if cfg.Default != nil && cfgName != DefaultConfigName {
-				this.logger.Info("apply default config ", zap.String("config", cfgName))
-				if err := this.applyConfig(cfg.Default.FilePath); err != nil {
-					return err
-				}
-			}
-			for i := range cfg.Profiles {
-				cfgFile := cfg.Profiles[i]
-				// apply special profile
-				if strings.EqualFold(cfgFile.Profile, activeProfile) {
-					this.logger.Info("apply config ", zap.String("config", cfgName), zap.String("profile", activeProfile))
-					if err := this.applyConfig(cfgFile.FilePath); err != nil {
-						return err
-					}
-				}
-			}
+		stopWatch, err := this.watchConfig()
+		if err != nil {
+			return err
 		}
+		defer stopWatch()
 	}
-	return nil
+	return this.execute()
 }
 
-func (this *_ctx) applyConfig(file string) error {
-	this.viper.SetConfigFile(file)
-	err := this.viper.MergeInConfig()
+// printUnitGraph print the unit graph to stdout
+func (this *_ctx) printUnitGraph(format string) error {
+	if _, err := this.resolveUnits(); err != nil {
+		return err
+	}
+	out, err := this.ExportUnitGraph(format)
 	if err != nil {
-		return errors.Wrapf(err, "load config %s error", file)
+		return err
 	}
-	return nil
+	_, err = os.Stdout.Write(out)
+	return err
 }
 
-func (this *_ctx) prepareConfigPath() {
-	if this.configFile != "" {
-		this.viper.SetConfigFile(this.configFile)
-	}
-	if this.configName != "" {
-		this.viper.SetConfigName(this.configName)
-		if this.configFileType != "" {
-			this.viper.SetConfigType(this.configFileType)
+func (this *_ctx) autoConfig() error {
+	this.logger.Info("Load config ...")
+	defineFlags(this.flagSet)
+	if !this.flagSet.Parsed() {
+		args := this.args
+		if args == nil {
+			args = os.Args[1:]
 		}
-		for pi := range this.configSearchPaths {
-			this.viper.AddConfigPath(this.configSearchPaths[pi])
+		if err := this.flagSet.Parse(args); err != nil {
+			return errors.Wrap(err, "parse command line failed")
 		}
 	}
-	if this.enableEnvOverride {
-		this.viper.AutomaticEnv()
-		if this.envPrefix != "" {
-			this.viper.SetEnvPrefix(this.envPrefix)
-		}
-		this.viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	loaded, err := this.loadConfig(this.GetViper())
+	if err != nil {
+		return err
 	}
+	this.useConfig(loaded)
+	return nil
 }
 
 func (this *_ctx) reinitLoggerIfNeeded() error {
 	oldLv := this.logLevel
-	this.logLevel = this.viper.GetString(CfgKeyAppLogLevel)
+	this.logLevel = this.GetViper().GetString(CfgKeyAppLogLevel)
 	lv, err := zapcore.ParseLevel(this.logLevel)
 	if err != nil {
 		return errors.New(fmt.Sprintf("invalid app.log.level %s", this.logLevel))
@@ -235,116 +283,3 @@ func (this *_ctx) reinitLoggerIfNeeded() error {
 	}
 	return nil
 }
-
-func (this *_ctx) execute() {
-	if len(this.units) == 0 {
-		this.logger.Warn("no unit to execute ,exit...")
-		return
-	}
-	this.handleKillSignal()
-	group := msync.NewAsyncTaskGroup()
-	defer group.Wait()
-	// execute units
-	taskStack := list.New()
-	defer func() {
-		for taskStack.Len() != 0 {
-			item := taskStack.Front()
-			taskStack.Remove(item)
-			taskItem := item.Value.(*unitImpl)
-			taskItem.Cancel()
-			taskItem.Wait()
-		}
-	}()
-
-	initer := func(unitItem *unitImpl) {
-		defer func() {
-			exitPanic := recover()
-			if exitPanic != nil {
-				this.logger.With(
-					log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Red, true))).
-					Fatal("init panic", zap.Any("error", exitPanic))
-			}
-		}()
-		this.logger.With(
-			log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Yellow, true))).
-			Info("start init...")
-		err := unitItem.Init(this)
-		if err != nil {
-			this.logger.With(
-				log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Red, true))).
-				Fatal("init failed  : ", zap.Error(err))
-			return
-		}
-		this.logger.With(
-			log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Green, true))).
-			Info("init success!")
-		taskStack.PushFront(unitItem)
-	}
-	runner := func(unitItem *unitImpl) {
-		group.AddTask(func() {
-			defer func() {
-				exitPanic := recover()
-				if exitPanic != nil {
-					this.logger.With(
-						log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Red, true))).
-						Panic("exit unexpected", zap.Any("error", exitPanic))
-				}
-			}()
-			this.logger.With(
-				log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Cyan, true))).
-				Info("running...")
-			result := unitItem.Exec()
-			exitTagColor := log.Cyan
-			var logMeth = this.logger.With(
-				log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), exitTagColor, true))).Info
-			if result.Code != 0 {
-				exitTagColor = log.Red
-				logMeth = this.logger.With(
-					log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), exitTagColor, true))).Warn
-			}
-			logMeth("exit", zap.Int("code", result.Code), zap.Error(result.Error))
-		})
-	}
-	// sort by dependencies
-	// a depends on b , then b should be before a
-	sort.SliceStable(this.units, func(i, j int) bool {
-		for _, dep := range this.units[j].depends {
-			if dep == this.units[i].GetName() {
-				return true
-			}
-			found := false
-			for idx := 0; idx < j; idx++ {
-				if dep == this.units[idx].GetName() {
-					found = true
-					break
-				}
-			}
-			if !found {
-				return true
-			}
-		}
-		return false
-	})
-
-	for idx := range this.units {
-		initer(this.units[idx])
-	}
-	for idx := range this.units {
-		runner(this.units[idx])
-	}
-	<-this.ctx.Done()
-}
-
-func (this *_ctx) kill() {
-	this.cancel()
-}
-
-func (this *_ctx) handleKillSignal() {
-	c := make(chan os.Signal)
-	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL)
-	go func() {
-		sig := <-c
-		this.logger.Info("Receive signal", zap.Any("signal", sig))
-		this.Shutdown(errors.New(fmt.Sprintf("System signal : %v", sig)))
-	}()
-}
@@ -0,0 +1,393 @@
+package kboot
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/guestin/log"
+	"github.com/guestin/mob/msync"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// execute init and run the units until shutdown, then stop them,
+// return *ExitError if units exited with a non-zero code
+func (this *_ctx) execute() error {
+	graph, err := this.resolveUnits()
+	if err != nil {
+		return err
+	}
+	if len(graph.order) == 0 {
+		this.logger.Warn("no unit to execute ,exit...")
+		return nil
+	}
+	this.logger.Info("unit init order", zap.Strings("units", graph.names()))
+	stopSignal := this.handleKillSignal()
+	defer stopSignal()
+	initErr := this.initUnits(graph)
+	if initErr == nil {
+		initErr = this.runHooks(stageInitialized, true)
+	}
+	if initErr != nil {
+		this.rollbackUnits(graph)
+		return initErr
+	}
+	group := msync.NewAsyncTaskGroup()
+	running := &sync.WaitGroup{}
+	running.Add(len(graph.order))
+	for _, unitItem := range graph.order {
+		group.AddTask(func() {
+			this.runUnit(graph, unitItem, sync.OnceFunc(running.Done))
+		})
+	}
+	allRunning := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(allRunning)
+	}()
+	var startErr error
+	select {
+	case <-allRunning:
+		startErr = this.runHooks(stageStarted, true)
+		if startErr != nil {
+			this.Shutdown(startErr)
+		}
+	case <-this.ctx.Done():
+		// shut down before all units are running, the started hooks are skipped
+	}
+	<-this.ctx.Done()
+	_ = this.runHooks(stageShutdown, false)
+	// stop units in reverse dependency order
+	exceeded := this.stopUnits(graph)
+	_ = this.runHooks(stageStopped, false)
+	if startErr != nil {
+		return startErr
+	}
+	return this.exitError(exceeded)
+}
+
+// initUnits init each unit as soon as all of its dependencies finished init,
+// at most initWorkers units at a time, return the first init error
+func (this *_ctx) initUnits(graph *unitGraph) error {
+	type initDone struct {
+		unit *unitImpl
+		err  error
+	}
+	pending := make(map[string]int, len(graph.order))
+	dependents := make(map[string][]*unitImpl, len(graph.order))
+	ready := make([]*unitImpl, 0, len(graph.order))
+	for _, unitItem := range graph.order {
+		deps := graph.deps[unitItem.GetName()]
+		pending[unitItem.GetName()] = len(deps)
+		for _, dep := range deps {
+			dependents[dep] = append(dependents[dep], unitItem)
+		}
+		if len(deps) == 0 {
+			ready = append(ready, unitItem)
+		}
+	}
+	workers := this.initWorkers(len(graph.order))
+	doneC := make(chan initDone, len(graph.order))
+	// the number of units finished init when each unit started and finished init,
+	// deps declared during init must have finished before the unit started
+	startedAt := make(map[string]int, len(graph.order))
+	doneAt := make(map[string]int, len(graph.order))
+	finished := 0
+	running := 0
+	initialized := make([]*unitImpl, 0, len(graph.order))
+	var firstErr error
+	for {
+		for firstErr == nil && len(ready) > 0 && running < workers {
+			unitItem := ready[0]
+			ready = ready[1:]
+			startedAt[unitItem.GetName()] = finished
+			running++
+			go func() {
+				doneC <- initDone{unit: unitItem, err: this.initUnit(unitItem)}
+			}()
+		}
+		if running == 0 {
+			if err := this.addLateDepends(graph, initialized); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			return firstErr
+		}
+		done := <-doneC
+		running--
+		name := done.unit.GetName()
+		err := done.err
+		if err == nil {
+			err = graph.checkLateDepends(done.unit, func(dep string) bool {
+				at, ok := doneAt[dep]
+				return ok && at <= startedAt[name]
+			})
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		initialized = append(initialized, done.unit)
+		finished++
+		doneAt[name] = finished
+		for _, dependent := range dependents[name] {
+			if pending[dependent.GetName()]--; pending[dependent.GetName()] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+}
+
+// addLateDepends add the dependencies declared during init to the graph and sort its levels again,
+// so that rollback, stop and the exported graph respect them
+func (this *_ctx) addLateDepends(graph *unitGraph, units []*unitImpl) error {
+	this.graphLock.Lock()
+	defer this.graphLock.Unlock()
+	added := false
+	for _, unitItem := range units {
+		if graph.addLateDepends(unitItem) {
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+	return errors.Wrap(graph.sortLevels(), "resolve unit dependencies declared during init failed")
+}
+
+func (this *_ctx) initUnit(unitItem *unitImpl) (err error) {
+	defer func() {
+		exitPanic := recover()
+		if exitPanic != nil {
+			this.logger.With(
+				log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Red, true))).
+				Error("init panic", zap.Any("error", exitPanic))
+			unitItem.initFailed()
+			err = errors.Errorf("unit '%s' init panic: %v", unitItem.GetName(), exitPanic)
+		}
+	}()
+	this.logger.With(
+		log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Yellow, true))).
+		Info("start init...")
+	err = unitItem.Init(this, this.initTimeoutOf(unitItem))
+	if err != nil {
+		this.logger.With(
+			log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Red, true))).
+			Error("init failed  : ", zap.Error(err))
+		return errors.Wrapf(err, "unit '%s' init failed", unitItem.GetName())
+	}
+	this.logger.With(
+		log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Green, true))).
+		Info("init success!")
+	return nil
+}
+
+// runUnit run the unit until it exits, started is called once the unit is running or gave up starting
+func (this *_ctx) runUnit(graph *unitGraph, unitItem *unitImpl, started func()) {
+	defer started()
+	defer func() {
+		// ExecFunc panics only reach here with PanicPolicyCrash
+		exitPanic := recover()
+		if exitPanic != nil {
+			this.logger.With(
+				log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Red, true))).
+				Panic("exit unexpected", zap.Any("error", exitPanic))
+		}
+	}()
+	if this.waitDependsReady && len(unitItem.depends) != 0 {
+		this.logger.With(
+			log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Yellow, true))).
+			Info("wait for dependencies ready...", zap.Strings("depends", unitItem.depends))
+		if err := unitItem.waitDependsReady(graph, this.readyTimeout); err != nil {
+			unitItem.setState(UnitStateExited)
+			if unitItem.ctx.Err() == nil {
+				this.recordExit(unitItem, NewBadResult(err))
+				this.Shutdown(errors.Wrap(err, "boot failed"))
+			}
+			unitItem.exit()
+			return
+		}
+	}
+	unitItem.setState(UnitStateRunning)
+	started()
+	this.logger.With(
+		log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Cyan, true))).
+		Info("running...")
+	result := unitItem.Exec()
+	exitTagColor := log.Cyan
+	var logMeth = this.logger.With(
+		log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), exitTagColor, true))).Info
+	if result.Code != 0 {
+		exitTagColor = log.Red
+		logMeth = this.logger.With(
+			log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), exitTagColor, true))).Warn
+	}
+	fields := []zap.Field{zap.Int("code", result.Code), zap.Error(result.Error)}
+	if unitItem.restart != nil {
+		fields = append(fields, zap.Int("restarts", unitItem.RestartCount()))
+	}
+	logMeth("exit", fields...)
+	this.recordExit(unitItem, result)
+	// close done only after the result is recorded, stopUnits returns once done is closed
+	unitItem.exit()
+	this.applyExitPolicy(unitItem, result)
+}
+
+// applyExitPolicy shut down the application if the exit policy of the unit asks for it
+func (this *_ctx) applyExitPolicy(unit *unitImpl, result ExitResult) {
+	if this.ctx.Err() != nil || unit.ctx.Err() != nil {
+		// already shutting down
+		return
+	}
+	policy := unit.exitPolicy
+	if policy == ExitPolicyIgnore && this.shutdownOnFailure {
+		policy = ExitPolicyShutdownOnFailure
+	}
+	if policy == ExitPolicyIgnore || (policy == ExitPolicyShutdownOnFailure && result.Code == 0) {
+		return
+	}
+	err := errors.Wrapf(result.Error, "unit '%s' exited with code %d", unit.GetName(), result.Code)
+	if err == nil {
+		err = errors.Errorf("unit '%s' exited with code %d", unit.GetName(), result.Code)
+	}
+	this.Shutdown(err)
+}
+
+// rollbackUnits tear down the initialized units in reverse init order after an init failure,
+// none of them has run, so they are cancelled and marked as exited before their StopFunc is called
+func (this *_ctx) rollbackUnits(graph *unitGraph) {
+	this.logger.Warn("init failed, rolling back initialized units ...")
+	for idx := len(graph.order) - 1; idx >= 0; idx-- {
+		unitItem := graph.order[idx]
+		if !unitItem.IsInitialized() {
+			continue
+		}
+		unitItem.Cancel()
+		unitItem.exit()
+		unitItem.setState(UnitStateExited)
+		this.callStopFunc(unitItem, this.stopTimeoutOf(unitItem))
+		this.logger.With(
+			log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Cyan, true))).
+			Info("rolled back")
+	}
+	this.logger.Warn("rollback done")
+}
+
+// stopUnits stop the initialized units level by level in reverse dependency order,
+// dependents are stopped before the units they depend on,
+// return the units which exceeded their stop deadline
+func (this *_ctx) stopUnits(graph *unitGraph) []string {
+	this.logger.Info("stopping units ...")
+	exceeded := make([]string, 0)
+	exceededLock := &sync.Mutex{}
+	for idx := len(graph.levels) - 1; idx >= 0; idx-- {
+		levelGroup := msync.NewAsyncTaskGroup()
+		for _, unitItem := range graph.levels[idx] {
+			if !unitItem.IsInitialized() {
+				continue
+			}
+			levelGroup.AddTask(func() {
+				timeout := this.stopTimeoutOf(unitItem)
+				start := time.Now()
+				if !unitItem.Stop(timeout) {
+					this.logger.With(
+						log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Red, true))).
+						Error("stop deadline exceeded, moving on", zap.Duration("timeout", timeout),
+							zap.Bool("stopFuncSkipped", unitItem.getStopFunc() != nil))
+					exceededLock.Lock()
+					exceeded = append(exceeded, unitItem.GetName())
+					exceededLock.Unlock()
+					return
+				}
+				this.logger.With(
+					log.UseSubTag(log.NewFixStyleText(unitItem.GetName(), log.Cyan, true))).
+					Info("stopped", zap.Duration("cost", time.Since(start)))
+				// the StopFunc gets what is left of the stop deadline
+				remaining := timeout
+				if timeout > 0 {
+					if remaining = timeout - time.Since(start); remaining <= 0 {
+						remaining = time.Millisecond
+					}
+				}
+				this.callStopFunc(unitItem, remaining)
+			})
+		}
+		levelGroup.Wait()
+	}
+	if len(exceeded) != 0 {
+		this.logger.Error("some units exceeded their stop deadline", zap.Strings("units", exceeded))
+		return exceeded
+	}
+	this.logger.Info("all units stopped")
+	return exceeded
+}
+
+// callStopFunc call the StopFunc of the unit if any, log its error and duration
+func (this *_ctx) callStopFunc(unit *unitImpl, timeout time.Duration) {
+	if unit.getStopFunc() == nil {
+		return
+	}
+	start := time.Now()
+	err := unit.callStopFunc(timeout)
+	if err != nil {
+		this.logger.With(
+			log.UseSubTag(log.NewFixStyleText(unit.GetName(), log.Red, true))).
+			Error("stop func failed", zap.Duration("cost", time.Since(start)), zap.Error(err))
+		return
+	}
+	this.logger.With(
+		log.UseSubTag(log.NewFixStyleText(unit.GetName(), log.Cyan, true))).
+		Info("stop func done", zap.Duration("cost", time.Since(start)))
+}
+
+// initTimeoutOf the init deadline of the unit, fallback to CfgKeyUnitsInitTimeout
+func (this *_ctx) initTimeoutOf(unit *unitImpl) time.Duration {
+	if unit.initTimeout > 0 {
+		return unit.initTimeout
+	}
+	return this.GetViper().GetDuration(CfgKeyUnitsInitTimeout)
+}
+
+// stopTimeoutOf the stop deadline of the unit, fallback to CfgKeyUnitsStopTimeout
+func (this *_ctx) stopTimeoutOf(unit *unitImpl) time.Duration {
+	if unit.stopTimeout > 0 {
+		return unit.stopTimeout
+	}
+	return this.GetViper().GetDuration(CfgKeyUnitsStopTimeout)
+}
+
+// initWorkers the number of units to init concurrently
+func (this *_ctx) initWorkers(units int) int {
+	if this.initParallelism > 0 && this.initParallelism < units {
+		return this.initParallelism
+	}
+	return units
+}
+
+func (this *_ctx) kill() {
+	this.cancel()
+}
+
+// handleKillSignal shut down on system signals, return the func to stop handling
+func (this *_ctx) handleKillSignal() func() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-c:
+			this.logger.Info("Receive signal", zap.Any("signal", sig))
+			this.Shutdown(errors.New(fmt.Sprintf("System signal : %v", sig)))
+		case <-stop:
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(stop)
+	}
+}
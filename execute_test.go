@@ -0,0 +1,347 @@
+package kboot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newTestExecContext create a context to drive the execution steps directly, its config is not loaded
+func newTestExecContext(options ...BootOption) *_ctx {
+	ctx := newContext()
+	ctx.Application = testApp{}
+	ctx.ctx, ctx.cancel = context.WithCancel(context.Background())
+	for _, opt := range options {
+		opt.apply(ctx)
+	}
+	return ctx
+}
+
+// resolveTestGraph resolve the graph of the registered units
+func resolveTestGraph(t *testing.T, ctx *_ctx) *unitGraph {
+	graph, err := resolveUnitGraph(ctx.units, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+func TestInitUnits_StartOnDependencies(t *testing.T) {
+	ctx := newTestExecContext()
+	apiInit := make(chan struct{})
+	ctx.RegisterUnit("slow", func(unit Unit) (ExecFunc, error) {
+		select {
+		case <-apiInit:
+			return nil, nil
+		case <-time.After(time.Second):
+			return nil, errors.New("api waited for the unrelated slow unit")
+		}
+	})
+	ctx.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		return nil, nil
+	})
+	ctx.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		close(apiInit)
+		return nil, nil
+	}, DependsOn("db"))
+	if err := ctx.initUnits(resolveTestGraph(t, ctx)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitUnits_LateDepends(t *testing.T) {
+	cases := []struct {
+		name    string
+		options []BootOption
+		// depends the unit b declares by DependsOn
+		depends []string
+		err     string
+	}{
+		{"serial", []BootOption{InitParallelism(1)}, nil, ""},
+		{"concurrent after init", nil, []string{"m"}, ""},
+		{"concurrent not initialized", nil, nil, "'a' is not initialized yet"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := newTestExecContext(c.options...)
+			ctx.RegisterUnit("a", func(unit Unit) (ExecFunc, error) {
+				return nil, nil
+			})
+			ctx.RegisterUnit("m", func(unit Unit) (ExecFunc, error) {
+				return nil, nil
+			}, DependsOn("a"))
+			ctx.RegisterUnit("b", func(unit Unit) (ExecFunc, error) {
+				unit.Depends("a")
+				return nil, nil
+			}, DependsOn(c.depends...))
+			err := ctx.initUnits(resolveTestGraph(t, ctx))
+			if c.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+				t.Fatalf("expect error %s, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+// startTestUnits init and run the units of the graph, wait until all of them are running
+func startTestUnits(t *testing.T, ctx *_ctx, graph *unitGraph) {
+	if err := ctx.initUnits(graph); err != nil {
+		t.Fatal(err)
+	}
+	running := &sync.WaitGroup{}
+	running.Add(len(graph.order))
+	for _, unitItem := range graph.order {
+		go ctx.runUnit(graph, unitItem, sync.OnceFunc(running.Done))
+	}
+	running.Wait()
+}
+
+// testRecorder record events from concurrent units
+type testRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (this *testRecorder) add(event string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.events = append(this.events, event)
+}
+
+func (this *testRecorder) String() string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return strings.Join(this.events, ",")
+}
+
+func TestStopUnits_ReverseOrder(t *testing.T) {
+	ctx := newTestExecContext()
+	stopped := &testRecorder{}
+	execFn := func(unit Unit) ExitResult {
+		<-unit.Done()
+		stopped.add(unit.GetName())
+		return NewSuccessResult()
+	}
+	initFn := func(unit Unit) (ExecFunc, error) {
+		return execFn, nil
+	}
+	ctx.RegisterUnit("http", initFn, DependsOn("service"))
+	ctx.RegisterUnit("service", initFn, DependsOn("db"))
+	ctx.RegisterUnit("db", initFn)
+	graph := resolveTestGraph(t, ctx)
+	startTestUnits(t, ctx, graph)
+	if exceeded := ctx.stopUnits(graph); len(exceeded) != 0 {
+		t.Fatalf("unexpected exceeded units %v", exceeded)
+	}
+	if got := stopped.String(); got != "http,service,db" {
+		t.Fatalf("unexpected stop order %s", got)
+	}
+}
+
+func TestStopUnits_LateDepends(t *testing.T) {
+	ctx := newTestExecContext(InitParallelism(1))
+	stopped := &testRecorder{}
+	execFn := func(unit Unit) ExitResult {
+		<-unit.Done()
+		stopped.add(unit.GetName())
+		return NewSuccessResult()
+	}
+	ctx.RegisterUnit("a", func(unit Unit) (ExecFunc, error) {
+		return execFn, nil
+	})
+	ctx.RegisterUnit("b", func(unit Unit) (ExecFunc, error) {
+		unit.Depends("a")
+		return func(unit Unit) ExitResult {
+			<-unit.Done()
+			// a stops first if both are stopped at once
+			time.Sleep(50 * time.Millisecond)
+			stopped.add(unit.GetName())
+			return NewSuccessResult()
+		}, nil
+	})
+	graph := resolveTestGraph(t, ctx)
+	startTestUnits(t, ctx, graph)
+	ctx.stopUnits(graph)
+	if got := stopped.String(); got != "b,a" {
+		t.Fatalf("expect b stopped before a, got %s", got)
+	}
+}
+
+func TestStopUnits_DeadlineExceeded(t *testing.T) {
+	ctx := newTestExecContext()
+	release := make(chan struct{})
+	defer close(release)
+	ctx.RegisterUnit("stuck", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			<-release
+			return NewSuccessResult()
+		}, nil
+	}, DependsOn("db"), StopTimeout(50*time.Millisecond))
+	dbStopped := make(chan struct{})
+	ctx.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			<-unit.Done()
+			close(dbStopped)
+			return NewSuccessResult()
+		}, nil
+	})
+	graph := resolveTestGraph(t, ctx)
+	startTestUnits(t, ctx, graph)
+	if exceeded := ctx.stopUnits(graph); strings.Join(exceeded, ",") != "stuck" {
+		t.Fatalf("expect stuck exceeded its deadline, got %v", exceeded)
+	}
+	select {
+	case <-dbStopped:
+	default:
+		t.Fatal("expect db stopped after stuck exceeded its deadline")
+	}
+}
+
+func TestRunUnit_WaitDependenciesReady(t *testing.T) {
+	ctx := newTestExecContext(WaitDependenciesReady(time.Second))
+	events := &testRecorder{}
+	ctx.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			events.add("api exec")
+			<-unit.Done()
+			return NewSuccessResult()
+		}, nil
+	}, DependsOn("db"))
+	ctx.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			time.Sleep(50 * time.Millisecond)
+			events.add("db ready")
+			unit.MarkReady()
+			<-unit.Done()
+			return NewSuccessResult()
+		}, nil
+	})
+	graph := resolveTestGraph(t, ctx)
+	startTestUnits(t, ctx, graph)
+	ctx.stopUnits(graph)
+	if got := events.String(); got != "db ready,api exec" {
+		t.Fatalf("expect api exec after db ready, got %s", got)
+	}
+}
+
+func TestRunUnit_ReadyTimeout(t *testing.T) {
+	ctx := newTestExecContext(WaitDependenciesReady(50 * time.Millisecond))
+	apiExec := atomic.Bool{}
+	ctx.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			apiExec.Store(true)
+			return NewSuccessResult()
+		}, nil
+	}, DependsOn("db"))
+	ctx.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			<-unit.Done()
+			return NewSuccessResult()
+		}, nil
+	})
+	graph := resolveTestGraph(t, ctx)
+	startTestUnits(t, ctx, graph)
+	api := graph.units[graph.index["api"]]
+	api.Wait()
+	if ctx.ctx.Err() == nil {
+		t.Fatal("expect boot failed on readiness timeout")
+	}
+	result := api.result.Load()
+	if result == nil || result.Code == 0 || result.Error == nil {
+		t.Fatalf("expect api exited with a bad result, got %v", result)
+	}
+	ctx.stopUnits(graph)
+	if apiExec.Load() {
+		t.Fatal("expect api not executed")
+	}
+}
+
+func TestInitUnits_Concurrent(t *testing.T) {
+	ctx := newTestExecContext()
+	entered := &sync.WaitGroup{}
+	entered.Add(2)
+	allEntered := make(chan struct{})
+	go func() {
+		entered.Wait()
+		close(allEntered)
+	}()
+	initFn := func(unit Unit) (ExecFunc, error) {
+		entered.Done()
+		select {
+		case <-allEntered:
+			return nil, nil
+		case <-time.After(time.Second):
+			return nil, errors.New("independent inits not running concurrently")
+		}
+	}
+	ctx.RegisterUnit("a", initFn)
+	ctx.RegisterUnit("b", initFn)
+	if err := ctx.initUnits(resolveTestGraph(t, ctx)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitUnits_Serial(t *testing.T) {
+	ctx := newTestExecContext(InitParallelism(1))
+	current := atomic.Int32{}
+	maxCurrent := atomic.Int32{}
+	initFn := func(unit Unit) (ExecFunc, error) {
+		n := current.Add(1)
+		defer current.Add(-1)
+		for {
+			m := maxCurrent.Load()
+			if n <= m || maxCurrent.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil, nil
+	}
+	ctx.RegisterUnit("a", initFn)
+	ctx.RegisterUnit("b", initFn)
+	ctx.RegisterUnit("c", initFn)
+	if err := ctx.initUnits(resolveTestGraph(t, ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if got := maxCurrent.Load(); got != 1 {
+		t.Fatalf("expect serial init, got %d inits at once", got)
+	}
+}
+
+func TestApplyExitPolicy_ShutdownOnAnyFailure(t *testing.T) {
+	cases := []struct {
+		name     string
+		options  []BootOption
+		code     int
+		shutdown bool
+	}{
+		{"failure ignored by default", nil, 2, false},
+		{"failure", []BootOption{ShutdownOnAnyFailure()}, 2, true},
+		{"success", []BootOption{ShutdownOnAnyFailure()}, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := newTestExecContext(c.options...)
+			ctx.RegisterUnit("worker", func(unit Unit) (ExecFunc, error) {
+				return func(unit Unit) ExitResult {
+					return ExitResult{Code: c.code}
+				}, nil
+			})
+			graph := resolveTestGraph(t, ctx)
+			if err := ctx.initUnits(graph); err != nil {
+				t.Fatal(err)
+			}
+			ctx.runUnit(graph, graph.units[graph.index["worker"]], func() {})
+			if shutdown := ctx.ctx.Err() != nil; shutdown != c.shutdown {
+				t.Fatalf("expect shutdown %v, got %v", c.shutdown, shutdown)
+			}
+		})
+	}
+}
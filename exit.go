@@ -0,0 +1,103 @@
+package kboot
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// UnitExitResult the final ExitResult of a unit
+type UnitExitResult struct {
+	Unit string
+	ExitResult
+}
+
+// ExitError returned by Instance.Run when the reduced exit code is non-zero
+type ExitError struct {
+	Code    int
+	Results []UnitExitResult
+}
+
+func (this *ExitError) Error() string {
+	return fmt.Sprintf("exit with code %d", this.Code)
+}
+
+// ExitCodeReducer reduce the ExitResults of all units into the process exit code,
+// results are in exit order
+type ExitCodeReducer func(results []UnitExitResult) int
+
+// FirstFailureExitCode the code of the first unit exited with a non-zero code, default
+func FirstFailureExitCode(results []UnitExitResult) int {
+	for _, r := range results {
+		if r.Code != 0 {
+			return r.Code
+		}
+	}
+	return 0
+}
+
+// MaxExitCode the max code of all units
+func MaxExitCode(results []UnitExitResult) int {
+	code := 0
+	for _, r := range results {
+		if r.Code > code {
+			code = r.Code
+		}
+	}
+	return code
+}
+
+// recordExit record the final ExitResult of the unit
+func (this *_ctx) recordExit(unit *unitImpl, result ExitResult) {
+	unit.result.Store(&result)
+	unit.setState(UnitStateExited)
+	this.resultsLock.Lock()
+	defer this.resultsLock.Unlock()
+	this.results = append(this.results, UnitExitResult{
+		Unit:       unit.GetName(),
+		ExitResult: result,
+	})
+}
+
+// exitError reduce the recorded results into the process exit code and log the summary,
+// units exceeded their stop deadline are marked in the summary, return nil if the code is 0
+func (this *_ctx) exitError(exceeded []string) error {
+	this.resultsLock.Lock()
+	results := append([]UnitExitResult{}, this.results...)
+	this.resultsLock.Unlock()
+	stopExceeded := make(map[string]bool, len(exceeded))
+	for _, name := range exceeded {
+		stopExceeded[name] = true
+	}
+	exitNote := func(name string) string {
+		if stopExceeded[name] {
+			return " (stop deadline exceeded)"
+		}
+		return ""
+	}
+	exited := make(map[string]bool, len(results))
+	summary := make([]string, 0, len(this.units))
+	for _, r := range results {
+		exited[r.Unit] = true
+		if r.Error != nil {
+			summary = append(summary, fmt.Sprintf("%s: code=%d error=%v%s", r.Unit, r.Code, r.Error, exitNote(r.Unit)))
+		} else {
+			summary = append(summary, fmt.Sprintf("%s: code=%d%s", r.Unit, r.Code, exitNote(r.Unit)))
+		}
+	}
+	for _, u := range this.units {
+		if u.IsInitialized() && !exited[u.GetName()] {
+			summary = append(summary, fmt.Sprintf("%s: not exited%s", u.GetName(), exitNote(u.GetName())))
+		}
+	}
+	reducer := this.exitCodeReducer
+	if reducer == nil {
+		reducer = FirstFailureExitCode
+	}
+	code := reducer(results)
+	this.logger.Info("exit summary", zap.Int("code", code), zap.Strings("units", summary))
+	if code == 0 {
+		return nil
+	}
+	return &ExitError{Code: code, Results: results}
+}
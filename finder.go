@@ -45,7 +45,8 @@ func (this *configFinderImpl) FindConfigs(dir string, exts ...string) (map[strin
 	if err != nil {
 		return nil, errors.Wrapf(err, "read config form path %s error", dir)
 	}
-	pattern := fmt.Sprintf("^([a-zA-Z]+[a-zA-Z0-9]*)[_.-]?([a-zA-Z0-9]*)\\.(%s)$", strings.Join(exts, "|"))
+	// the config name is followed by an optional profile, which may contain '-' and '_' such as eu-west
+	pattern := fmt.Sprintf("^([a-zA-Z]+[a-zA-Z0-9]*)(?:[_.-]([a-zA-Z0-9][a-zA-Z0-9_-]*))?\\.(%s)$", strings.Join(exts, "|"))
 	reg := regexp.MustCompile(pattern)
 	result := make(map[string]*configItem)
 	for _, f := range files {
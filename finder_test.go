@@ -1,6 +1,10 @@
 package kboot
 
 import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/guestin/log"
@@ -14,3 +18,36 @@ func TestFinderImpl_FindConfigs(t *testing.T) {
 	finder := newConfigFinder(logger)
 	_, _ = finder.FindConfigs("./test/config", viper.SupportedExts...)
 }
+
+func TestFinderImpl_FindConfigs_Profiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"application.yaml", "application-prod.yaml", "application-eu-west.yaml",
+		"application_eu_central.yml", "db.yaml", "db.prod.yaml", "readme.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rootLogger, _ := log.EasyInitConsoleLogger(zap.DebugLevel, zap.DPanicLevel)
+	finder := newConfigFinder(log.NewTaggedZapLogger(rootLogger, "test"))
+	found, err := finder.FindConfigs(dir, "yaml", "yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found["application"].Default == nil || found["db"].Default == nil {
+		t.Fatalf("unexpected configs %v", found)
+	}
+	profiles := func(item *configItem) string {
+		ret := make([]string, 0, len(item.Profiles))
+		for _, f := range item.Profiles {
+			ret = append(ret, f.Profile)
+		}
+		sort.Strings(ret)
+		return strings.Join(ret, ",")
+	}
+	if got := profiles(found["application"]); got != "eu-west,eu_central,prod" {
+		t.Fatalf("unexpected application profiles %s", got)
+	}
+	if got := profiles(found["db"]); got != "prod" {
+		t.Fatalf("unexpected db profiles %s", got)
+	}
+}
@@ -0,0 +1,45 @@
+package kboot
+
+import (
+	"github.com/spf13/pflag"
+)
+
+const (
+	// FlagPrintGraph print the resolved unit graph in special format (dot|json) and exit without running units
+	FlagPrintGraph = "kboot.print-graph"
+	// FlagPrintConfigOrigin print every effective config key with its value and origin and exit
+	FlagPrintConfigOrigin = "kboot.print-config-origin"
+	// FlagCheck validate the config and the unit graph and exit without running units, see CheckMode
+	FlagCheck = "kboot.check"
+)
+
+// defineFlags define the kboot flags on the flag set if not defined yet
+func defineFlags(fs *pflag.FlagSet) {
+	if fs.Lookup(FlagPrintGraph) == nil {
+		fs.String(FlagPrintGraph, "", "print the unit graph (dot|json) and exit")
+	}
+	if fs.Lookup(FlagPrintConfigOrigin) == nil {
+		fs.Bool(FlagPrintConfigOrigin, false, "print the origin of every config key and exit")
+	}
+	if fs.Lookup(FlagCheck) == nil {
+		fs.Bool(FlagCheck, false, "validate the config and the unit graph and exit")
+	}
+}
+
+// flagString the value of a kboot flag, empty if not defined
+func (this *_ctx) flagString(name string) string {
+	if this.flagSet.Lookup(name) == nil {
+		return ""
+	}
+	v, _ := this.flagSet.GetString(name)
+	return v
+}
+
+// flagBool the value of a kboot flag, false if not defined
+func (this *_ctx) flagBool(name string) bool {
+	if this.flagSet.Lookup(name) == nil {
+		return false
+	}
+	v, _ := this.flagSet.GetBool(name)
+	return v
+}
@@ -0,0 +1,220 @@
+package kboot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// unitGraph the resolved dependency graph of units
+type unitGraph struct {
+	// units in registration order
+	units []*unitImpl
+	index map[string]int
+	// disabled units and the reason
+	disabled map[string]string
+	// deps resolved dependencies of each unit, duplicates removed
+	deps map[string][]string
+	// levels units grouped by dependency depth,
+	// units of the same level do not depend on each other
+	levels [][]*unitImpl
+	// order the init order, dependencies always come before dependents
+	order []*unitImpl
+}
+
+// resolveUnitGraph build the dependency graph of units and sort them topologically,
+// units without dependency relationship keep their registration order
+func resolveUnitGraph(units []*unitImpl, disabled map[string]string) (*unitGraph, error) {
+	g := &unitGraph{
+		units:    units,
+		index:    make(map[string]int, len(units)),
+		disabled: disabled,
+		deps:     make(map[string][]string, len(units)),
+	}
+	for idx, u := range units {
+		g.index[u.GetName()] = idx
+	}
+	for _, u := range units {
+		seen := make(map[string]bool, len(u.depends))
+		deps := make([]string, 0, len(u.depends))
+		for _, dep := range u.depends {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			if err := g.checkKnown(u, dep); err != nil {
+				return nil, err
+			}
+			deps = append(deps, dep)
+		}
+		g.deps[u.GetName()] = deps
+	}
+	if err := g.sortLevels(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// sortLevels group the units by dependency depth and compute the init order
+func (this *unitGraph) sortLevels() error {
+	// Kahn's algorithm, one round per level
+	pending := make([]int, len(this.units))
+	dependents := make([][]int, len(this.units))
+	for idx, u := range this.units {
+		for _, dep := range this.deps[u.GetName()] {
+			pending[idx]++
+			depIdx := this.index[dep]
+			dependents[depIdx] = append(dependents[depIdx], idx)
+		}
+	}
+	placed := make([]bool, len(this.units))
+	levels := make([][]*unitImpl, 0)
+	order := make([]*unitImpl, 0, len(this.units))
+	for len(order) < len(this.units) {
+		level := make([]*unitImpl, 0)
+		for idx, u := range this.units {
+			if !placed[idx] && pending[idx] == 0 {
+				level = append(level, u)
+			}
+		}
+		if len(level) == 0 {
+			return errors.Errorf("dependency cycle detected: %s", this.findCycle(placed))
+		}
+		for _, u := range level {
+			idx := this.index[u.GetName()]
+			placed[idx] = true
+			for _, dependent := range dependents[idx] {
+				pending[dependent]--
+			}
+		}
+		levels = append(levels, level)
+		order = append(order, level...)
+	}
+	this.levels, this.order = levels, order
+	return nil
+}
+
+// findCycle return the first dependency cycle among the units not placed yet,
+// formatted as 'a -> b -> a'
+func (this *unitGraph) findCycle(placed []bool) string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(this.units))
+	stack := make([]string, 0)
+	var visit func(idx int) []string
+	visit = func(idx int) []string {
+		state[idx] = visiting
+		name := this.units[idx].GetName()
+		stack = append(stack, name)
+		for _, dep := range this.deps[name] {
+			depIdx := this.index[dep]
+			if placed[depIdx] {
+				continue
+			}
+			switch state[depIdx] {
+			case visiting:
+				for pos := range stack {
+					if stack[pos] == dep {
+						return append(append([]string{}, stack[pos:]...), dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(depIdx); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[idx] = visited
+		return nil
+	}
+	for idx := range this.units {
+		if placed[idx] || state[idx] != unvisited {
+			continue
+		}
+		if cycle := visit(idx); cycle != nil {
+			return strings.Join(cycle, " -> ")
+		}
+	}
+	return "unknown"
+}
+
+// checkLateDepends verify dependencies declared by Unit.Depends during init,
+// they must be known units which have already been initialized
+func (this *unitGraph) checkLateDepends(unit *unitImpl, initialized func(name string) bool) error {
+	declared := make(map[string]bool, len(this.deps[unit.GetName()]))
+	for _, dep := range this.deps[unit.GetName()] {
+		declared[dep] = true
+	}
+	for _, dep := range unit.depends {
+		if declared[dep] {
+			continue
+		}
+		if err := this.checkKnown(unit, dep); err != nil {
+			return err
+		}
+		if dep == unit.GetName() {
+			return errors.Errorf("dependency cycle detected: %s -> %s", dep, dep)
+		}
+		if !initialized(dep) {
+			return errors.New(fmt.Sprintf(
+				"unit '%s' declared dependency '%s' during init, but '%s' is not initialized yet, use DependsOn instead",
+				unit.GetName(), dep, dep))
+		}
+	}
+	return nil
+}
+
+// addLateDepends add the dependencies declared by Unit.Depends during init to the graph,
+// they must have passed checkLateDepends, return whether any was added
+func (this *unitGraph) addLateDepends(unit *unitImpl) bool {
+	name := unit.GetName()
+	added := false
+	for _, dep := range unit.depends {
+		known := false
+		for _, d := range this.deps[name] {
+			if d == dep {
+				known = true
+				break
+			}
+		}
+		if !known {
+			this.deps[name] = append(this.deps[name], dep)
+			added = true
+		}
+	}
+	return added
+}
+
+// checkKnown verify the dependency is an enabled unit
+func (this *unitGraph) checkKnown(unit *unitImpl, dep string) error {
+	if _, ok := this.index[dep]; ok {
+		return nil
+	}
+	if reason, ok := this.disabled[dep]; ok {
+		return errors.Errorf("unit '%s' depends on disabled unit '%s' (%s)", unit.GetName(), dep, reason)
+	}
+	return errors.Errorf("unit '%s' depends on unknown unit '%s'", unit.GetName(), dep)
+}
+
+// unit find the unit by name, return nil if not found
+func (this *unitGraph) unit(name string) *unitImpl {
+	idx, ok := this.index[name]
+	if !ok {
+		return nil
+	}
+	return this.units[idx]
+}
+
+// names return the unit names in init order
+func (this *unitGraph) names() []string {
+	ret := make([]string, 0, len(this.order))
+	for _, u := range this.order {
+		ret = append(ret, u.GetName())
+	}
+	return ret
+}
@@ -0,0 +1,138 @@
+package kboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	GraphFormatDOT  = "dot"
+	GraphFormatJSON = "json"
+)
+
+type (
+	// UnitGraph the resolved unit dependency graph
+	UnitGraph struct {
+		Units []UnitNode `json:"units"`
+		// InitOrder names of enabled units in init order
+		InitOrder []string `json:"initOrder"`
+		// Levels names of enabled units grouped by dependency depth, units of a level do not depend on each other
+		Levels [][]string `json:"levels"`
+	}
+
+	UnitNode struct {
+		Name           string   `json:"name"`
+		Depends        []string `json:"depends"`
+		Enabled        bool     `json:"enabled"`
+		DisabledReason string   `json:"disabledReason,omitempty"`
+		// Level the dependency depth, -1 if disabled
+		Level int `json:"level"`
+	}
+)
+
+// resolveUnits register the built-in units, drop the disabled ones
+// and resolve the dependency graph of the rest,
+// units are resolved only once, later calls return the same result
+func (this *_ctx) resolveUnits() (*unitGraph, error) {
+	this.graphLock.Lock()
+	defer this.graphLock.Unlock()
+	if this.graph != nil || this.graphErr != nil {
+		return this.graph, this.graphErr
+	}
+	this.registerAdminUnit()
+	units := this.resolveEnabledUnits()
+	this.graph, this.graphErr = resolveUnitGraph(units, this.disabledUnits)
+	if this.graphErr != nil {
+		this.graphErr = errors.Wrap(this.graphErr, "resolve unit dependencies failed")
+	}
+	return this.graph, this.graphErr
+}
+
+// GetUnitGraph the unit graph resolved by Run once the config is loaded
+func (this *_ctx) GetUnitGraph() (*UnitGraph, error) {
+	// the graph is sorted again once deps declared during init are added
+	this.graphLock.Lock()
+	defer this.graphLock.Unlock()
+	graph, err := this.graph, this.graphErr
+	if err != nil {
+		return nil, err
+	}
+	if graph == nil {
+		return nil, errors.New("unit graph not resolved yet, it is resolved by Run once the config is loaded")
+	}
+	levels := make(map[string]int, len(graph.order))
+	ret := &UnitGraph{
+		Units:     make([]UnitNode, 0, len(this.units)),
+		InitOrder: graph.names(),
+		Levels:    make([][]string, 0, len(graph.levels)),
+	}
+	for idx, level := range graph.levels {
+		names := make([]string, 0, len(level))
+		for _, u := range level {
+			names = append(names, u.GetName())
+			levels[u.GetName()] = idx
+		}
+		ret.Levels = append(ret.Levels, names)
+	}
+	for _, u := range this.units {
+		node := UnitNode{
+			Name:    u.GetName(),
+			Depends: append([]string{}, u.depends...),
+			Enabled: true,
+			Level:   -1,
+		}
+		if reason, disabled := this.disabledUnits[u.GetName()]; disabled {
+			node.Enabled = false
+			node.DisabledReason = reason
+		} else {
+			node.Level = levels[u.GetName()]
+			node.Depends = append([]string{}, graph.deps[u.GetName()]...)
+		}
+		ret.Units = append(ret.Units, node)
+	}
+	return ret, nil
+}
+
+func (this *_ctx) ExportUnitGraph(format string) ([]byte, error) {
+	graph, err := this.GetUnitGraph()
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(format) {
+	case GraphFormatJSON:
+		return json.MarshalIndent(graph, "", "  ")
+	case GraphFormatDOT:
+		return graph.dot(this.GetAppName()), nil
+	default:
+		return nil, errors.Errorf("unsupported graph format '%s', expect %s or %s", format, GraphFormatDOT, GraphFormatJSON)
+	}
+}
+
+// dot render the graph in graphviz dot, edges point from dependents to dependencies
+func (this *UnitGraph) dot(name string) []byte {
+	order := make(map[string]int, len(this.InitOrder))
+	for idx, n := range this.InitOrder {
+		order[n] = idx + 1
+	}
+	buf := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(buf, "digraph %q {\n", name)
+	buf.WriteString("  node [shape=box];\n")
+	for _, u := range this.Units {
+		if u.Enabled {
+			_, _ = fmt.Fprintf(buf, "  %q [label=%q];\n", u.Name, fmt.Sprintf("%s\n#%d level %d", u.Name, order[u.Name], u.Level))
+		} else {
+			_, _ = fmt.Fprintf(buf, "  %q [label=%q, style=dashed, color=gray];\n", u.Name, fmt.Sprintf("%s\ndisabled", u.Name))
+		}
+	}
+	for _, u := range this.Units {
+		for _, dep := range u.Depends {
+			_, _ = fmt.Fprintf(buf, "  %q -> %q;\n", u.Name, dep)
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
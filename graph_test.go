@@ -0,0 +1,91 @@
+package kboot
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestUnits(spec ...[]string) []*unitImpl {
+	units := make([]*unitImpl, 0, len(spec))
+	for _, item := range spec {
+		units = append(units, &unitImpl{name: item[0], depends: item[1:]})
+	}
+	return units
+}
+
+func TestResolveUnitGraph_Order(t *testing.T) {
+	g, err := resolveUnitGraph(newTestUnits(
+		[]string{"http", "service"},
+		[]string{"service", "db", "cache"},
+		[]string{"cache", "db"},
+		[]string{"db"},
+		[]string{"metrics"},
+	), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(g.names(), ",")
+	want := "db,metrics,cache,service,http"
+	if got != want {
+		t.Fatalf("unexpected order %s, want %s", got, want)
+	}
+	if len(g.levels) != 4 {
+		t.Fatalf("unexpected levels %d", len(g.levels))
+	}
+}
+
+func TestResolveUnitGraph_Cycle(t *testing.T) {
+	_, err := resolveUnitGraph(newTestUnits(
+		[]string{"a", "b"},
+		[]string{"b", "c"},
+		[]string{"c", "a"},
+		[]string{"d"},
+	), nil)
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Fatalf("expect cycle error, got %v", err)
+	}
+}
+
+func TestResolveUnitGraph_Unknown(t *testing.T) {
+	_, err := resolveUnitGraph(newTestUnits(
+		[]string{"a", "dbb"},
+		[]string{"db"},
+	), nil)
+	if err == nil || !strings.Contains(err.Error(), "'a' depends on unknown unit 'dbb'") {
+		t.Fatalf("expect unknown unit error, got %v", err)
+	}
+}
+
+func TestResolveUnitGraph_Disabled(t *testing.T) {
+	_, err := resolveUnitGraph(newTestUnits(
+		[]string{"consumer", "mq"},
+	), map[string]string{"mq": "none of profiles [prod] is activated"})
+	if err == nil || !strings.Contains(err.Error(), "depends on disabled unit 'mq'") {
+		t.Fatalf("expect disabled unit error, got %v", err)
+	}
+}
+
+func TestUnitGraph_AddLateDepends(t *testing.T) {
+	g, err := resolveUnitGraph(newTestUnits(
+		[]string{"a"},
+		[]string{"b"},
+		[]string{"c"},
+	), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := g.unit("b")
+	b.depends = append(b.depends, "a")
+	if !g.addLateDepends(b) || g.addLateDepends(b) {
+		t.Fatal("expect the late dependency added once")
+	}
+	if err := g.sortLevels(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(g.names(), ","); got != "a,c,b" {
+		t.Fatalf("unexpected order %s", got)
+	}
+	if len(g.levels) != 2 {
+		t.Fatalf("unexpected levels %d", len(g.levels))
+	}
+}
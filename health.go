@@ -0,0 +1,180 @@
+package kboot
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/guestin/mob/msync"
+)
+
+// UnitState the lifecycle state of a unit
+type UnitState int32
+
+const (
+	UnitStateRegistered UnitState = iota
+	UnitStateInitializing
+	UnitStateRunning
+	UnitStateRestarting
+	UnitStateExited
+)
+
+func (this UnitState) String() string {
+	switch this {
+	case UnitStateRegistered:
+		return "registered"
+	case UnitStateInitializing:
+		return "initializing"
+	case UnitStateRunning:
+		return "running"
+	case UnitStateRestarting:
+		return "restarting"
+	case UnitStateExited:
+		return "exited"
+	default:
+		return "unknown"
+	}
+}
+
+func (this UnitState) MarshalText() ([]byte, error) {
+	return []byte(this.String()), nil
+}
+
+// HealthCheckFunc check the health of a dependency of the unit, return nil if healthy
+type HealthCheckFunc func(ctx context.Context) error
+
+// DefaultHealthCheckTimeout the max duration of a single health check
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+type healthCheck struct {
+	name string
+	fn   HealthCheckFunc
+}
+
+type (
+	HealthReport struct {
+		// Live false if any unit exited with a non-zero code
+		Live bool `json:"live"`
+		// Ready true if all units are running or exited successfully, and all health checks passed
+		Ready bool         `json:"ready"`
+		Units []UnitHealth `json:"units"`
+	}
+
+	UnitHealth struct {
+		Name     string        `json:"name"`
+		State    UnitState     `json:"state"`
+		Live     bool          `json:"live"`
+		Ready    bool          `json:"ready"`
+		Restarts int           `json:"restarts"`
+		ExitCode *int          `json:"exitCode,omitempty"`
+		Error    string        `json:"error,omitempty"`
+		Checks   []CheckResult `json:"checks,omitempty"`
+	}
+
+	CheckResult struct {
+		Name    string `json:"name"`
+		Healthy bool   `json:"healthy"`
+		Error   string `json:"error,omitempty"`
+	}
+)
+
+func (this *unitImpl) RegisterHealthCheck(name string, fn HealthCheckFunc) {
+	if fn == nil {
+		return
+	}
+	this.checksLock.Lock()
+	defer this.checksLock.Unlock()
+	this.checks = append(this.checks, healthCheck{name: name, fn: fn})
+}
+
+func (this *unitImpl) State() UnitState {
+	return UnitState(atomic.LoadInt32(&this.state))
+}
+
+func (this *unitImpl) setState(state UnitState) {
+	atomic.StoreInt32(&this.state, int32(state))
+}
+
+// health compute the health of the unit, run its health checks if it is running
+func (this *unitImpl) health(ctx context.Context) UnitHealth {
+	state := this.State()
+	ret := UnitHealth{
+		Name:     this.GetName(),
+		State:    state,
+		Live:     true,
+		Restarts: this.RestartCount(),
+	}
+	result := this.result.Load()
+	if result != nil {
+		code := result.Code
+		ret.ExitCode = &code
+		if result.Error != nil {
+			ret.Error = result.Error.Error()
+		}
+	}
+	switch state {
+	case UnitStateRunning:
+		ret.Ready = true
+	case UnitStateExited:
+		ret.Live = result == nil || result.Code == 0
+		ret.Ready = ret.Live
+	}
+	if state != UnitStateRunning {
+		return ret
+	}
+	this.checksLock.Lock()
+	checks := append([]healthCheck{}, this.checks...)
+	this.checksLock.Unlock()
+	ret.Checks = make([]CheckResult, len(checks))
+	group := msync.NewAsyncTaskGroup()
+	for idx, check := range checks {
+		group.AddTask(func() {
+			checkCtx, cancel := context.WithTimeout(ctx, DefaultHealthCheckTimeout)
+			defer cancel()
+			ret.Checks[idx] = CheckResult{Name: check.name, Healthy: true}
+			if err := check.fn(checkCtx); err != nil {
+				ret.Checks[idx].Healthy = false
+				ret.Checks[idx].Error = err.Error()
+			}
+		})
+	}
+	group.Wait()
+	for _, check := range ret.Checks {
+		if !check.Healthy {
+			ret.Ready = false
+		}
+	}
+	return ret
+}
+
+func (this *_ctx) Health(ctx context.Context) HealthReport {
+	units := this.enabledUnits()
+	report := HealthReport{
+		Live:  true,
+		Ready: true,
+		Units: make([]UnitHealth, len(units)),
+	}
+	group := msync.NewAsyncTaskGroup()
+	for idx, u := range units {
+		group.AddTask(func() {
+			report.Units[idx] = u.health(ctx)
+		})
+	}
+	group.Wait()
+	for _, u := range report.Units {
+		report.Live = report.Live && u.Live
+		report.Ready = report.Ready && u.Ready
+	}
+	return report
+}
+
+// enabledUnits the units not disabled, in registration order
+func (this *_ctx) enabledUnits() []*unitImpl {
+	ret := make([]*unitImpl, 0, len(this.units))
+	for _, u := range this.units {
+		if _, disabled := this.disabledUnits[u.GetName()]; !disabled {
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
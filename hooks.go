@@ -0,0 +1,107 @@
+package kboot
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// HookFunc the application lifecycle hook
+type HookFunc func(ctx Context) error
+
+type lifecycleStage int
+
+const (
+	stageConfigLoaded lifecycleStage = iota
+	stageInitialized
+	stageStarted
+	stageShutdown
+	stageStopped
+)
+
+func (this lifecycleStage) String() string {
+	switch this {
+	case stageConfigLoaded:
+		return "config-loaded"
+	case stageInitialized:
+		return "initialized"
+	case stageStarted:
+		return "started"
+	case stageShutdown:
+		return "shutdown"
+	case stageStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// OnConfigLoaded register a hook to the default instance, see Instance.OnConfigLoaded
+func OnConfigLoaded(fn HookFunc) {
+	_gCtx.OnConfigLoaded(fn)
+}
+
+// OnInitialized register a hook to the default instance, see Instance.OnInitialized
+func OnInitialized(fn HookFunc) {
+	_gCtx.OnInitialized(fn)
+}
+
+// OnStarted register a hook to the default instance, see Instance.OnStarted
+func OnStarted(fn HookFunc) {
+	_gCtx.OnStarted(fn)
+}
+
+// OnShutdown register a hook to the default instance, see Instance.OnShutdown
+func OnShutdown(fn HookFunc) {
+	_gCtx.OnShutdown(fn)
+}
+
+// OnStopped register a hook to the default instance, see Instance.OnStopped
+func OnStopped(fn HookFunc) {
+	_gCtx.OnStopped(fn)
+}
+
+func (this *_ctx) OnConfigLoaded(fn HookFunc) {
+	this.addHook(stageConfigLoaded, fn)
+}
+
+func (this *_ctx) OnInitialized(fn HookFunc) {
+	this.addHook(stageInitialized, fn)
+}
+
+func (this *_ctx) OnStarted(fn HookFunc) {
+	this.addHook(stageStarted, fn)
+}
+
+func (this *_ctx) OnShutdown(fn HookFunc) {
+	this.addHook(stageShutdown, fn)
+}
+
+func (this *_ctx) OnStopped(fn HookFunc) {
+	this.addHook(stageStopped, fn)
+}
+
+func (this *_ctx) addHook(stage lifecycleStage, fn HookFunc) {
+	if fn == nil {
+		return
+	}
+	if this.hooks == nil {
+		this.hooks = make(map[lifecycleStage][]HookFunc)
+	}
+	this.hooks[stage] = append(this.hooks[stage], fn)
+}
+
+// runHooks run the hooks of stage in registration order,
+// stop at the first error if abortOnError, otherwise errors are only logged
+func (this *_ctx) runHooks(stage lifecycleStage, abortOnError bool) error {
+	hooks := this.hooks[stage]
+	for idx, fn := range hooks {
+		this.logger.Info("run hook", zap.Stringer("stage", stage), zap.Int("index", idx))
+		if err := fn(this); err != nil {
+			if abortOnError {
+				return errors.Wrapf(err, "%s hook #%d failed", stage, idx)
+			}
+			this.logger.Error("hook failed", zap.Stringer("stage", stage), zap.Int("index", idx), zap.Error(err))
+		}
+	}
+	return nil
+}
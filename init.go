@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/guestin/log"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"go.uber.org/zap"
@@ -14,30 +15,61 @@ import (
 var _initOnce = &sync.Once{}
 
 func init() {
+	_initOnce.Do(func() {
+		_gCtx = newContext()
+	})
+}
+
+// newContext create a context with the default settings
+func newContext() *_ctx {
 	// init logger
 	lv, err := zapcore.ParseLevel(DefaultLogLevel)
 	if err != nil {
 		panic(err)
 	}
 	rootLogger, _ := log.EasyInitConsoleLogger(lv, zap.ErrorLevel)
-	_initOnce.Do(func() {
-		_gCtx = &_ctx{
-			ctx:               context.Background(),
-			viper:             viper.New(),
-			logLevel:          DefaultLogLevel,
-			hideBanner:        false,
-			rootLogger:        rootLogger,
-			logger:            log.NewTaggedZapLogger(rootLogger, LoggerTag),
-			units:             make([]*unitImpl, 0),
-			configName:        DefaultConfigName,
-			configFileType:    "",
-			configSearchPaths: []string{DefaultConfigFilePath},
-			configFile:        "",
-			configData:        nil,
-			enableEnvOverride: true,
-			envPrefix:         DefaultConfigEnvPrefix,
-		}
-		_gCtx.viper.SetDefault(CfgKeyAppTz, DefaultAppTz.String())
-		_gCtx.viper.SetDefault(CfgKeyAppLogLevel, DefaultLogLevel)
-	})
+	ctx := &_ctx{
+		ctx:               context.Background(),
+		cancel:            func() {},
+		viper:             newViper(),
+		logLevel:          DefaultLogLevel,
+		hideBanner:        false,
+		configSources:     make([]ConfigSource, 0),
+		configOrigins:     make(map[string]ConfigSource),
+		configTemplates:   make(map[string]interface{}),
+		profiles:          make([]string, 0),
+		hotReload:         false,
+		configWatchers:    make([]*configWatcher, 0),
+		rootLogger:        rootLogger,
+		logger:            log.NewTaggedZapLogger(rootLogger, LoggerTag),
+		units:             make([]*unitImpl, 0),
+		services:          newServiceRegistry(),
+		configName:        DefaultConfigName,
+		configFileType:    "",
+		configSearchPaths: []string{DefaultConfigFilePath},
+		configFile:        "",
+		configData:        nil,
+		enableEnvOverride: true,
+		envPrefix:         DefaultConfigEnvPrefix,
+		flagSet:           pflag.CommandLine,
+		args:              nil,
+		initParallelism:   0,
+		waitDependsReady:  false,
+		readyTimeout:      0,
+		shutdownOnFailure: false,
+		exitCodeReducer:   FirstFailureExitCode,
+		checkMode:         false,
+		panicPolicy:       PanicPolicyRecover,
+	}
+	defineFlags(ctx.flagSet)
+	return ctx
+}
+
+// newViper create a viper with the kboot defaults
+func newViper() *viper.Viper {
+	v := viper.New()
+	v.SetDefault(CfgKeyAppTz, DefaultAppTz.String())
+	v.SetDefault(CfgKeyAppLogLevel, DefaultLogLevel)
+	v.SetDefault(CfgKeyUnitsStopTimeout, DefaultUnitStopTimeout)
+	return v
 }
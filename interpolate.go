@@ -0,0 +1,254 @@
+package kboot
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// placeholderResolver resolve the placeholders in config values, strings in lists and maps included:
+//   - ${key} the value of another config key, falls back to the env var named key
+//   - ${key:default} the default if neither the key nor the env var is set, may contain placeholders
+//   - $${ the literal ${
+type placeholderResolver struct {
+	v *viper.Viper
+	// origins the source which last set each key
+	origins  map[string]ConfigSource
+	resolved map[string]interface{}
+	// chain the keys being resolved, to detect cycles
+	chain []string
+}
+
+// interpolateConfig resolve the placeholders of the string values merged from config files,
+// bytes and defaults, values from env and flags are used as is
+func interpolateConfig(loaded *loadedConfig) error {
+	resolver := &placeholderResolver{
+		v:        loaded.viper,
+		origins:  loaded.origins,
+		resolved: make(map[string]interface{}),
+	}
+	keys := loaded.viper.AllKeys()
+	sort.Strings(keys)
+	settings := make(map[string]interface{})
+	for _, key := range keys {
+		if resolver.isOverride(key) {
+			continue
+		}
+		raw := loaded.viper.Get(key)
+		if !hasPlaceholder(raw) {
+			continue
+		}
+		value, err := resolver.resolveKey(key)
+		if err != nil {
+			return err
+		}
+		loaded.templates[key] = raw
+		setNested(settings, strings.Split(key, "."), value)
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+	return loaded.viper.MergeConfigMap(settings)
+}
+
+// hasPlaceholder whether the value or any string in it contains a placeholder
+func hasPlaceholder(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return strings.Contains(v, "${")
+	case []string:
+		for _, item := range v {
+			if hasPlaceholder(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasPlaceholder(item) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if hasPlaceholder(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveKey the value of the key with placeholders resolved
+func (this *placeholderResolver) resolveKey(key string) (interface{}, error) {
+	if value, ok := this.resolved[key]; ok {
+		return value, nil
+	}
+	for idx, k := range this.chain {
+		if k == key {
+			cycle := append(append([]string{}, this.chain[idx:]...), key)
+			return nil, errors.Errorf("placeholder cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if this.isOverride(key) {
+		return this.v.Get(key), nil
+	}
+	this.chain = append(this.chain, key)
+	defer func() {
+		this.chain = this.chain[:len(this.chain)-1]
+	}()
+	value, err := this.resolveValue(this.v.Get(key))
+	if err != nil {
+		return nil, err
+	}
+	this.resolved[key] = value
+	return value, nil
+}
+
+// isOverride whether the value of the key comes from env or flags, such values are used as is,
+// keys only set by AutomaticEnv have no origin, unlike the parents of nested keys
+func (this *placeholderResolver) isOverride(key string) bool {
+	if origin, ok := this.origins[key]; ok {
+		return origin.Kind == ConfigSourceEnv || origin.Kind == ConfigSourceFlag
+	}
+	for k := range this.origins {
+		if strings.HasPrefix(k, key+".") {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveValue resolve the placeholders of the strings in value, lists and maps are copied
+func (this *placeholderResolver) resolveValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return this.resolve(v)
+	case []string:
+		ret := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			resolved, err := this.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, resolved)
+		}
+		return ret, nil
+	case []interface{}:
+		ret := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			resolved, err := this.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, resolved)
+		}
+		return ret, nil
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			resolved, err := this.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = resolved
+		}
+		return ret, nil
+	default:
+		return value, nil
+	}
+}
+
+// resolve replace the placeholders in s
+func (this *placeholderResolver) resolve(s string) (string, error) {
+	buf := &strings.Builder{}
+	for idx := 0; idx < len(s); {
+		if strings.HasPrefix(s[idx:], "$${") {
+			buf.WriteString("${")
+			idx += 3
+			continue
+		}
+		if !strings.HasPrefix(s[idx:], "${") {
+			buf.WriteByte(s[idx])
+			idx++
+			continue
+		}
+		end := closingBrace(s, idx+2)
+		if end < 0 {
+			return "", errors.Errorf("unclosed placeholder in [%s]: %s", this.current(), s[idx:])
+		}
+		value, err := this.resolveExpr(s[idx+2 : end])
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(value)
+		idx = end + 1
+	}
+	return buf.String(), nil
+}
+
+// resolveExpr resolve the content of a placeholder, key or key:default
+func (this *placeholderResolver) resolveExpr(expr string) (string, error) {
+	name, def, hasDef := strings.Cut(expr, ":")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.Errorf("empty placeholder in [%s]", this.current())
+	}
+	if key := strings.ToLower(name); this.v.IsSet(key) {
+		value, err := this.resolveKey(key)
+		if err != nil {
+			return "", err
+		}
+		return cast.ToString(value), nil
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	}
+	if hasDef {
+		return this.resolve(def)
+	}
+	return "", errors.Errorf("unresolved placeholder '${%s}' in [%s]", name, this.current())
+}
+
+// current the key being resolved
+func (this *placeholderResolver) current() string {
+	if len(this.chain) == 0 {
+		return ""
+	}
+	return this.chain[len(this.chain)-1]
+}
+
+// closingBrace the index of the brace closing the placeholder whose content starts at start,
+// -1 if not closed
+func closingBrace(s string, start int) int {
+	depth := 1
+	for idx := start; idx < len(s); idx++ {
+		switch {
+		case strings.HasPrefix(s[idx:], "${"):
+			depth++
+			idx++
+		case s[idx] == '}':
+			depth--
+			if depth == 0 {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+// setNested set the value at the path of nested maps
+func setNested(m map[string]interface{}, path []string, value interface{}) {
+	for _, seg := range path[:len(path)-1] {
+		sub, ok := m[seg].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[seg] = sub
+		}
+		m = sub
+	}
+	m[path[len(path)-1]] = value
+}
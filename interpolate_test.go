@@ -0,0 +1,96 @@
+package kboot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// newTestResolver create a resolver of the settings, all of them are loaded from a config file
+func newTestResolver(settings map[string]interface{}) *placeholderResolver {
+	v := viper.New()
+	origins := make(map[string]ConfigSource)
+	for key, value := range settings {
+		v.Set(key, value)
+		origins[key] = ConfigSource{Kind: ConfigSourceFile, Name: "application.yaml"}
+	}
+	return &placeholderResolver{
+		v:        v,
+		origins:  origins,
+		resolved: make(map[string]interface{}),
+	}
+}
+
+func TestPlaceholderResolver_Resolve(t *testing.T) {
+	t.Setenv("KBOOT_TEST_HOST", "db.local")
+	resolver := newTestResolver(map[string]interface{}{
+		"db.port": 5432,
+		"db.url":  "${db.host:localhost}:${db.port}",
+	})
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"plain", "plain"},
+		{"$${db.port}", "${db.port}"},
+		{"a$${b}${db.port}", "a${b}5432"},
+		{"${db.url}", "localhost:5432"},
+		{"${missing:${db.port}}", "5432"},
+		{"${missing:${other:fallback}}", "fallback"},
+		{"${missing:}", ""},
+		{"${KBOOT_TEST_HOST}", "db.local"},
+	}
+	for _, c := range cases {
+		got, err := resolver.resolve(c.expr)
+		if err != nil {
+			t.Fatalf("resolve %s: %v", c.expr, err)
+		}
+		if got != c.want {
+			t.Fatalf("resolve %s: expect %s, got %s", c.expr, c.want, got)
+		}
+	}
+}
+
+func TestPlaceholderResolver_Errors(t *testing.T) {
+	resolver := newTestResolver(map[string]interface{}{
+		"a": "${b}",
+		"b": "${a}",
+	})
+	cases := []struct {
+		expr string
+		err  string
+	}{
+		{"${db.host", "unclosed placeholder"},
+		{"${missing:${other}", "unclosed placeholder"},
+		{"${}", "empty placeholder"},
+		{"${missing}", "unresolved placeholder '${missing}'"},
+		{"${a}", "placeholder cycle detected: a -> b -> a"},
+	}
+	for _, c := range cases {
+		_, err := resolver.resolve(c.expr)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("resolve %s: expect error %s, got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestPlaceholderResolver_EnvAsIs(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "p${x")
+	t.Setenv("DB_USER", "u${y}")
+	resolver := newTestResolver(map[string]interface{}{
+		"db.dsn": "${DB_USER}:${DB_PASSWORD}",
+	})
+	resolver.v.AutomaticEnv()
+	resolver.v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	// an env override of a config key is used as is too
+	resolver.v.Set("db.user", "${z}")
+	resolver.origins["db.user"] = ConfigSource{Kind: ConfigSourceEnv, Name: "DB_USER"}
+	got, err := resolver.resolve("${db.dsn}|${db.user}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "u${y}:p${x|${z}" {
+		t.Fatalf("unexpected value %s", got)
+	}
+}
@@ -2,18 +2,54 @@ package kboot
 
 import (
 	"context"
-	"fmt"
-	"strings"
+	"os"
 
 	"github.com/guestin/log"
 	"github.com/ooopSnake/assert.go"
+	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
+// Instance an application instance independent of the package level default one
+type Instance interface {
+	Context
+	// HideBanner hide the bootstrap banner
+	HideBanner()
+	// RegisterUnit register a unit, must be called before Run
+	RegisterUnit(name string, fn InitFunc, options ...UnitOption)
+	// OnConfigLoaded register a hook run after the config is loaded, an error aborts boot
+	OnConfigLoaded(fn HookFunc)
+	// OnInitialized register a hook run after all units are initialized, an error aborts boot
+	OnInitialized(fn HookFunc)
+	// OnStarted register a hook run after all units are running, an error shuts down the application,
+	// skipped if shutdown is requested before
+	OnStarted(fn HookFunc)
+	// OnShutdown register a hook run when shutdown is requested, before units are stopped
+	OnShutdown(fn HookFunc)
+	// OnStopped register a hook run after all units are stopped
+	OnStopped(fn HookFunc)
+	// OnConfigChange subscribe the changes of the key made by config reloads, see ConfigHotReload
+	OnConfigChange(key string, fn ConfigChangeFunc)
+	// Run boot the application and block until it stops,
+	// return *ExitError if units exited with a non-zero code
+	Run(ctx context.Context) error
+}
+
+// New create an application instance
+func New(app Application, options ...BootOption) Instance {
+	assert.Must(app != nil, "app must not be nil").Panic()
+	ctx := newContext()
+	ctx.Application = app
+	for _, opt := range options {
+		opt.apply(ctx)
+	}
+	return ctx
+}
+
 // HideBanner hide the bootstrap banner
 func HideBanner() {
-	_gCtx.hideBanner = true
+	_gCtx.HideBanner()
 }
 
 func Version() string {
@@ -32,6 +68,10 @@ func GetActivatedProfile() string {
 	return GetContext().GetActivatedProfile()
 }
 
+func GetActivatedProfiles() []string {
+	return GetContext().GetActivatedProfiles()
+}
+
 func GetRootLogger() *zap.Logger {
 	return _gCtx.rootLogger
 }
@@ -48,38 +88,31 @@ func UnmarshalSubConfig(key string, i interface{}, options ...CfgOption) (err er
 	return GetContext().UnmarshalSubConfig(key, i, options...)
 }
 
+func GetRestartCount(unit string) int {
+	return GetContext().GetRestartCount(unit)
+}
+
 func RegisterUnit(name string, fn InitFunc, options ...UnitOption) {
-	assert.Must(len(strings.TrimSpace(name)) != 0, "name must not empty or blank").Panic()
-	assert.Must(fn != nil, "init func must not be nil").Panic()
-	for _, u := range _gCtx.units {
-		if u.GetName() == name {
-			assert.Must(false, fmt.Sprintf("name '%s' already exist", name)).Panic()
-		}
-	}
-	unit := &unitImpl{
-		rootCtx:  _gCtx,
-		name:     name,
-		initFunc: fn,
-	}
-	for _, opt := range options {
-		opt.apply(unit)
-	}
-	_gCtx.units = append(_gCtx.units, unit)
+	_gCtx.RegisterUnit(name, fn, options...)
 }
 
+// Bootstrap boot the application and block until it stops,
+// the process exits with a non-zero code if boot failed or units exited with a non-zero code
 func Bootstrap(ctx context.Context, app Application, options ...BootOption) {
-	if !_gCtx.hideBanner {
-		fmt.Print(_BANNER)
-	}
-	_gCtx.logger.Info("Bootstrap ... ", zap.String("app", app.GetAppName()), zap.String("tz", app.GetTimezone().String()))
-	assert.Must(ctx != nil, "root ctx must not be nil").Panic()
 	assert.Must(app != nil, "app must not be nil").Panic()
-	_ctx, cancel := context.WithCancel(ctx)
-	_gCtx.ctx = _ctx
-	_gCtx.cancel = cancel
 	_gCtx.Application = app
 	for _, opt := range options {
 		opt.apply(_gCtx)
 	}
-	_gCtx.bootStrap()
+	err := _gCtx.Run(ctx)
+	if err == nil {
+		return
+	}
+	exitErr := &ExitError{}
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
+	_gCtx.logger.Error("bootstrap failed", zap.Error(err))
+	_ = _gCtx.rootLogger.Sync()
+	os.Exit(1)
 }
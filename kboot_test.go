@@ -0,0 +1,549 @@
+package kboot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+type testApp struct{}
+
+func (testApp) GetAppName() string          { return "test" }
+func (testApp) GetTimezone() *time.Location { return time.UTC }
+
+// newTestInstance create an instance with an empty command line
+// and the working directory changed to a temporary one with an empty ./config
+func newTestInstance(t *testing.T, options ...BootOption) Instance {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, DefaultConfigFilePath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	options = append([]BootOption{
+		CommandLine(pflag.NewFlagSet("test", pflag.ContinueOnError), []string{}),
+	}, options...)
+	inst := New(testApp{}, options...)
+	inst.HideBanner()
+	return inst
+}
+
+// writeTestConfig write a config file into ./config
+func writeTestConfig(t *testing.T, name, content string) {
+	err := os.WriteFile(filepath.Join(DefaultConfigFilePath, name), []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstance_Run(t *testing.T) {
+	inst := newTestInstance(t)
+	order := make(chan string, 2)
+	inst.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		order <- unit.GetName()
+		return func(unit Unit) ExitResult {
+			return NewExitResult(3, errors.New("api failed"))
+		}, nil
+	}, DependsOn("db"), Critical())
+	inst.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		order <- unit.GetName()
+		return nil, nil
+	})
+	err := inst.Run(context.Background())
+	exitErr := &ExitError{}
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("expect exit code 3, got %v", err)
+	}
+	if first := <-order; first != "db" {
+		t.Fatalf("expect db init first, got %s", first)
+	}
+}
+
+func TestInstance_InitFailed(t *testing.T) {
+	inst := newTestInstance(t)
+	var pool Unit
+	stopped := false
+	inst.RegisterUnit("pool", WithStop(func(unit Unit) (ExecFunc, StopFunc, error) {
+		pool = unit
+		return nil, func(ctx context.Context) error {
+			stopped = true
+			return nil
+		}, nil
+	}))
+	inst.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		return nil, errors.New("connection refused")
+	}, DependsOn("pool"))
+	err := inst.Run(context.Background())
+	if err == nil || err.Error() != "unit 'db' init failed: connection refused" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !stopped {
+		t.Fatal("expect the stop func of pool called on rollback")
+	}
+	select {
+	case <-pool.Done():
+	default:
+		t.Fatal("expect pool done after rollback")
+	}
+	for _, u := range inst.(*_ctx).units {
+		if u.IsInitialized() != (u.GetName() == "pool") || u.State() != UnitStateExited {
+			t.Fatalf("unexpected unit '%s' initialized=%v state=%s", u.GetName(), u.IsInitialized(), u.State())
+		}
+	}
+}
+
+func TestInstance_ConditionalUnits(t *testing.T) {
+	inst := newTestInstance(t)
+	writeTestConfig(t, "application.yaml", "feature:\n  cache: true\n")
+	inits := make(chan string, 4)
+	initFn := func(unit Unit) (ExecFunc, error) {
+		inits <- unit.GetName()
+		return func(unit Unit) ExitResult {
+			return NewSuccessResult()
+		}, nil
+	}
+	inst.RegisterUnit("redis", initFn, ConditionalOnProperty("feature.cache", "true"))
+	inst.RegisterUnit("local-cache", initFn, ConditionalOnMissingUnit("redis"), Critical())
+	inst.RegisterUnit("mock", initFn, OnProfile("dev", "test"))
+	inst.RegisterUnit("stop", initFn, DependsOn("redis"), Critical())
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(inits)
+	got := make([]string, 0)
+	for name := range inits {
+		got = append(got, name)
+	}
+	if len(got) != 2 || got[0] != "redis" || got[1] != "stop" {
+		t.Fatalf("unexpected enabled units %v", got)
+	}
+}
+
+type testDBConfig struct {
+	Host string `mapstructure:"host" validate:"required"`
+	Port int    `mapstructure:"port"`
+}
+
+func TestInstance_ConfiguredUnit(t *testing.T) {
+	inst := newTestInstance(t)
+	writeTestConfig(t, "application.yaml", "db:\n  host: localhost\n  port: 5432\ncache:\n  port: 6379\n")
+	RegisterConfiguredUnitTo(inst, "db", "db", func(unit Unit, cfg *testDBConfig) (ExecFunc, error) {
+		if stored, ok := UnitConfig[testDBConfig](unit); !ok || stored != cfg {
+			t.Error("typed config not retrievable from unit")
+		}
+		if cfg.Host != "localhost" || cfg.Port != 5432 {
+			t.Errorf("unexpected config %+v", cfg)
+		}
+		return nil, nil
+	})
+	RegisterConfiguredUnitTo(inst, "cache", "cache", func(unit Unit, cfg *testDBConfig) (ExecFunc, error) {
+		t.Error("init must not be called with an invalid config")
+		return nil, nil
+	})
+	err := inst.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid [cache] config") {
+		t.Fatalf("expect invalid config error, got %v", err)
+	}
+}
+
+type testPool struct {
+	dsn string
+}
+
+func TestInstance_ServiceRegistry(t *testing.T) {
+	inst := newTestInstance(t)
+	inst.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		return nil, Provide(unit, &testPool{dsn: "mem"})
+	})
+	inst.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		pool, err := Lookup[*testPool](unit)
+		if err != nil || pool.dsn != "mem" {
+			t.Errorf("lookup failed %v", err)
+		}
+		return nil, nil
+	}, DependsOn("db"))
+	inst.RegisterUnit("rogue", func(unit Unit) (ExecFunc, error) {
+		_, err := Lookup[*testPool](unit)
+		return nil, err
+	}, DependsOn("api"))
+	err := inst.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "provided by unit 'db', but 'rogue' does not depend on 'db'") {
+		t.Fatalf("expect lookup error, got %v", err)
+	}
+}
+
+func TestInstance_Hooks(t *testing.T) {
+	inst := newTestInstance(t)
+	stages := make([]string, 0)
+	hook := func(stage string) HookFunc {
+		return func(ctx Context) error {
+			stages = append(stages, stage)
+			if stage == "started" {
+				ctx.Shutdown(nil)
+			}
+			return nil
+		}
+	}
+	inst.OnStopped(hook("stopped"))
+	inst.OnShutdown(hook("shutdown"))
+	inst.OnStarted(hook("started"))
+	inst.OnInitialized(hook("initialized"))
+	inst.OnConfigLoaded(hook("config-loaded"))
+	inst.RegisterUnit("worker", func(unit Unit) (ExecFunc, error) {
+		return nil, nil
+	})
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(stages, ",")
+	if got != "config-loaded,initialized,started,shutdown,stopped" {
+		t.Fatalf("unexpected hook order %s", got)
+	}
+}
+
+func TestInstance_Health(t *testing.T) {
+	inst := newTestInstance(t)
+	inst.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		unit.RegisterHealthCheck("db", func(ctx context.Context) error {
+			return errors.New("db unreachable")
+		})
+		return func(unit Unit) ExitResult {
+			<-unit.Done()
+			return NewSuccessResult()
+		}, nil
+	})
+	var report HealthReport
+	inst.RegisterUnit("probe", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			for i := 0; i < 100; i++ {
+				report = inst.Health(unit.GetContext())
+				if report.Units[0].State == UnitStateRunning {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return NewSuccessResult()
+		}, nil
+	}, Critical())
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Live || report.Ready {
+		t.Fatalf("expect live but not ready, got %+v", report)
+	}
+	api := report.Units[0]
+	if len(api.Checks) != 1 || api.Checks[0].Healthy || api.Checks[0].Error != "db unreachable" {
+		t.Fatalf("unexpected checks %+v", api.Checks)
+	}
+}
+
+func TestInstance_StopFunc(t *testing.T) {
+	inst := newTestInstance(t)
+	events := make([]string, 0)
+	inst.RegisterUnit("pool", WithStop(func(unit Unit) (ExecFunc, StopFunc, error) {
+		return func(unit Unit) ExitResult {
+				<-unit.Done()
+				events = append(events, "exec returned")
+				return NewSuccessResult()
+			}, func(ctx context.Context) error {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("expect stop deadline")
+				}
+				events = append(events, "stopped")
+				return nil
+			}, nil
+	}), StopTimeout(time.Second))
+	inst.OnStarted(func(ctx Context) error {
+		ctx.Shutdown(nil)
+		return nil
+	})
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(events, ",") != "exec returned,stopped" {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
+
+func TestInstance_InitTimeout(t *testing.T) {
+	inst := newTestInstance(t)
+	released := make(chan struct{})
+	inst.RegisterUnit("broker", WithStop(func(unit Unit) (ExecFunc, StopFunc, error) {
+		if _, ok := unit.GetContext().Deadline(); !ok {
+			t.Error("expect init deadline")
+		}
+		<-unit.GetContext().Done()
+		time.Sleep(50 * time.Millisecond)
+		return nil, func(ctx context.Context) error {
+			close(released)
+			return nil
+		}, nil
+	}), InitTimeout(50*time.Millisecond))
+	err := inst.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unit 'broker' init failed: init timed out after 50ms") {
+		t.Fatalf("expect init timeout error, got %v", err)
+	}
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("expect the stop func of the timed out init called")
+	}
+}
+
+func TestInstance_DisabledByConfig(t *testing.T) {
+	inst := newTestInstance(t)
+	writeTestConfig(t, "application.yaml", "kboot:\n  units:\n    disabled: [mq]\n    metrics:\n      enabled: false\n")
+	initFn := func(unit Unit) (ExecFunc, error) {
+		t.Errorf("unit '%s' must not init", unit.GetName())
+		return nil, nil
+	}
+	inst.RegisterUnit("mq", initFn)
+	inst.RegisterUnit("metrics", initFn)
+	inst.RegisterUnit("consumer", initFn, DependsOn("mq"))
+	err := inst.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unit 'consumer' depends on disabled unit 'mq'") {
+		t.Fatalf("expect disabled dependency error, got %v", err)
+	}
+}
+
+func TestInstance_PrintGraph(t *testing.T) {
+	inst := newTestInstance(t, CommandLine(pflag.NewFlagSet("test", pflag.ContinueOnError),
+		[]string{"--" + FlagPrintGraph + "=dot"}))
+	initFn := func(unit Unit) (ExecFunc, error) {
+		t.Errorf("unit '%s' must not init", unit.GetName())
+		return nil, nil
+	}
+	inst.RegisterUnit("api", initFn, DependsOn("db"))
+	inst.RegisterUnit("db", initFn)
+	inst.RegisterUnit("mock", initFn, OnProfile("test"))
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	out, err := inst.ExportUnitGraph(GraphFormatDOT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"api" -> "db";`, `"mock" [label="mock\ndisabled", style=dashed`} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("expect %s in\n%s", want, out)
+		}
+	}
+	graph, err := inst.GetUnitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(graph.InitOrder, ",") != "db,api" || graph.Units[2].Enabled {
+		t.Fatalf("unexpected graph %+v", graph)
+	}
+}
+
+func TestInstance_CheckMode(t *testing.T) {
+	initFn := func(unit Unit, cfg *testDBConfig) (ExecFunc, error) {
+		t.Errorf("unit '%s' must not init", unit.GetName())
+		return nil, nil
+	}
+	inst := newTestInstance(t, CheckMode())
+	writeTestConfig(t, "application.yaml", "db:\n  host: localhost\n")
+	RegisterConfiguredUnitTo(inst, "db", "db", initFn)
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	inst = newTestInstance(t, CommandLine(pflag.NewFlagSet("test", pflag.ContinueOnError),
+		[]string{"--" + FlagCheck}))
+	RegisterConfiguredUnitTo(inst, "db", "db", initFn)
+	RegisterConfiguredUnitTo(inst, "cache", "cache", initFn, DependsOn("mq"))
+	err := inst.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "3 problem(s)") {
+		t.Fatalf("expect 3 problems, got %v", err)
+	}
+}
+
+func TestInstance_ExecPanic(t *testing.T) {
+	inst := newTestInstance(t)
+	inst.RegisterUnit("worker", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			panic("boom")
+		}, nil
+	}, Critical())
+	inst.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		return nil, nil
+	})
+	err := inst.Run(context.Background())
+	exitErr := &ExitError{}
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expect exit code 1, got %v", err)
+	}
+	for _, result := range exitErr.Results {
+		if result.Unit != "worker" {
+			continue
+		}
+		panicErr := &PanicError{}
+		if !errors.As(result.Error, &panicErr) || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+			t.Fatalf("expect a panic error, got %v", result.Error)
+		}
+		return
+	}
+	t.Fatalf("no result of worker in %+v", exitErr.Results)
+}
+
+type testLimitConfig struct {
+	Rate int `mapstructure:"rate" validate:"min=1"`
+}
+
+func TestInstance_ConfigHotReload(t *testing.T) {
+	inst := newTestInstance(t, ConfigHotReload())
+	writeTestConfig(t, "application.yaml", "limit:\n  rate: 1\n")
+	changes := make(chan int, 4)
+	WatchTo(inst, "limit", func(old, new *testLimitConfig) {
+		changes <- new.Rate
+	})
+	started := make(chan struct{})
+	inst.OnStarted(func(ctx Context) error {
+		close(started)
+		return nil
+	})
+	inst.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		return nil, nil
+	})
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- inst.Run(context.Background())
+	}()
+	<-started
+	writeTestConfig(t, "application.yaml", "limit:\n  rate: 2\n")
+	select {
+	case rate := <-changes:
+		if rate != 2 {
+			t.Fatalf("expect rate 2, got %d", rate)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change not notified")
+	}
+	writeTestConfig(t, "application.yaml", "limit:\n  rate: 0\n")
+	time.Sleep(DefaultConfigReloadDelay * 3)
+	if rate := inst.GetViper().GetInt("limit.rate"); rate != 2 || len(changes) != 0 {
+		t.Fatalf("expect the invalid reload rejected, got rate %d", rate)
+	}
+	inst.Shutdown(nil)
+	if err := <-runErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstance_UnitGraphWhileRunning(t *testing.T) {
+	inst := newTestInstance(t)
+	inst.RegisterUnit("mock", func(unit Unit) (ExecFunc, error) {
+		return nil, nil
+	}, OnProfile("test"))
+	inst.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 10; i++ {
+					inst.Health(context.Background())
+				}
+			}()
+			for i := 0; i < 10; i++ {
+				graph, err := inst.GetUnitGraph()
+				if err != nil {
+					return NewBadResult(err)
+				}
+				if graph.Units[0].Enabled {
+					return NewBadResult(errors.New("expect mock disabled"))
+				}
+			}
+			<-done
+			return NewSuccessResult()
+		}, nil
+	}, Critical())
+	if _, err := inst.GetUnitGraph(); err == nil {
+		t.Fatal("expect an error before the graph is resolved")
+	}
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstance_ExitResultOnStop(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		inst := newTestInstance(t)
+		inst.RegisterUnit("worker", func(unit Unit) (ExecFunc, error) {
+			return func(unit Unit) ExitResult {
+				<-unit.Done()
+				return NewExitResult(5, errors.New("flush failed"))
+			}, nil
+		})
+		inst.RegisterUnit("main", func(unit Unit) (ExecFunc, error) {
+			return func(unit Unit) ExitResult {
+				return NewSuccessResult()
+			}, nil
+		}, Critical())
+		err := inst.Run(context.Background())
+		exitErr := &ExitError{}
+		if !errors.As(err, &exitErr) || exitErr.Code != 5 {
+			t.Fatalf("expect exit code 5, got %v", err)
+		}
+	}
+}
+
+func TestInstance_StopFuncSkippedOnDeadline(t *testing.T) {
+	inst := newTestInstance(t)
+	release := make(chan struct{})
+	defer close(release)
+	stopped := make(chan struct{}, 1)
+	inst.RegisterUnit("stuck", WithStop(func(unit Unit) (ExecFunc, StopFunc, error) {
+		return func(unit Unit) ExitResult {
+				<-release
+				return NewSuccessResult()
+			}, func(ctx context.Context) error {
+				stopped <- struct{}{}
+				return nil
+			}, nil
+	}), StopTimeout(50*time.Millisecond))
+	inst.OnStarted(func(ctx Context) error {
+		ctx.Shutdown(nil)
+		return nil
+	})
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(stopped) != 0 {
+		t.Fatal("expect the stop func skipped while the exec func is still running")
+	}
+}
+
+func TestInstance_StartedAfterAllRunning(t *testing.T) {
+	inst := newTestInstance(t, WaitDependenciesReady(time.Second))
+	inst.RegisterUnit("db", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			time.Sleep(100 * time.Millisecond)
+			unit.MarkReady()
+			<-unit.Done()
+			return NewSuccessResult()
+		}, nil
+	})
+	inst.RegisterUnit("api", func(unit Unit) (ExecFunc, error) {
+		return func(unit Unit) ExitResult {
+			<-unit.Done()
+			return NewSuccessResult()
+		}, nil
+	}, DependsOn("db"))
+	inst.OnStarted(func(ctx Context) error {
+		defer ctx.Shutdown(nil)
+		for _, u := range ctx.Health(context.Background()).Units {
+			if u.State != UnitStateRunning {
+				return errors.Errorf("unit '%s' is %s when started hooks run", u.Name, u.State)
+			}
+		}
+		return nil
+	})
+	if err := inst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
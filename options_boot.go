@@ -2,6 +2,9 @@ package kboot
 
 import (
 	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
 )
 
 type BootOption Option[*_ctx]
@@ -46,3 +49,77 @@ func ConfigEnvOverride(enable bool, prefix ...string) BootOption {
 		}
 	})
 }
+
+// InitParallelism limit the number of units initialized concurrently,
+// a unit starts init as soon as all of its dependencies finished init,
+// n <= 0 means no limit (default) and 1 means strict serial init
+func InitParallelism(n int) BootOption {
+	return optionFunc[*_ctx](func(ctx *_ctx) {
+		ctx.initParallelism = n
+	})
+}
+
+// WaitDependenciesReady only invoke the ExecFunc of a unit once all of its
+// dependencies have signalled ready by Unit.MarkReady,
+// boot fails if the dependencies are not ready within timeout, timeout <= 0 means wait forever
+func WaitDependenciesReady(timeout time.Duration) BootOption {
+	return optionFunc[*_ctx](func(ctx *_ctx) {
+		ctx.waitDependsReady = true
+		ctx.readyTimeout = timeout
+	})
+}
+
+// ShutdownOnAnyFailure shut down the application when any unit exits with a non-zero code,
+// units with ExitPolicyIgnore are treated as ExitPolicyShutdownOnFailure
+func ShutdownOnAnyFailure() BootOption {
+	return optionFunc[*_ctx](func(ctx *_ctx) {
+		ctx.shutdownOnFailure = true
+	})
+}
+
+// ExitCodeOf the reducer to compute the process exit code from the ExitResults of units,
+// default is FirstFailureExitCode
+func ExitCodeOf(reducer ExitCodeReducer) BootOption {
+	return optionFunc[*_ctx](func(ctx *_ctx) {
+		ctx.exitCodeReducer = reducer
+	})
+}
+
+// ConfigHotReload watch the merged config files and reload on changes,
+// all layers are merged again into a fresh viper, which is swapped in only if the typed configs
+// of enabled units and the subscribers of Watch can parse and validate it,
+// subscribers of changed keys are notified after the swap,
+// values set directly on GetViper are not carried over to the reloaded config
+func ConfigHotReload() BootOption {
+	return optionFunc[*_ctx](func(ctx *_ctx) {
+		ctx.hotReload = true
+	})
+}
+
+// DefaultPanicPolicy the policy applied when the ExecFunc of a unit panics,
+// default is PanicPolicyRecover, override per unit by UsePanicPolicy
+func DefaultPanicPolicy(policy PanicPolicy) BootOption {
+	return optionFunc[*_ctx](func(ctx *_ctx) {
+		ctx.panicPolicy = policy
+	})
+}
+
+// CommandLine parse the command line from special flag set and arguments,
+// default is pflag.CommandLine and os.Args[1:], the flag set is not parsed again if already parsed
+func CommandLine(flagSet *pflag.FlagSet, arguments []string) BootOption {
+	return optionFunc[*_ctx](func(ctx *_ctx) {
+		if flagSet != nil {
+			ctx.flagSet = flagSet
+		}
+		ctx.args = arguments
+	})
+}
+
+// CheckMode only load the config, validate typed configs of units and the unit graph,
+// print a report and exit without calling any InitFunc or ExecFunc,
+// Instance.Run returns an error if any problem is found, same as the FlagCheck flag
+func CheckMode() BootOption {
+	return optionFunc[*_ctx](func(ctx *_ctx) {
+		ctx.checkMode = true
+	})
+}
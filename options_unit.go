@@ -1,5 +1,7 @@
 package kboot
 
+import "time"
+
 type UnitOption Option[*unitImpl]
 
 func DependsOn(dep ...string) UnitOption {
@@ -7,3 +9,51 @@ func DependsOn(dep ...string) UnitOption {
 		unit.depends = append(unit.depends, dep...)
 	})
 }
+
+// InitTimeout the max duration of the InitFunc, Unit.GetContext carries the deadline during init,
+// override the global default CfgKeyUnitsInitTimeout
+func InitTimeout(d time.Duration) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		unit.initTimeout = d
+	})
+}
+
+// StopTimeout the max duration to wait for the unit to exit on shutdown, its StopFunc included,
+// override the global default CfgKeyUnitsStopTimeout
+func StopTimeout(d time.Duration) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		unit.stopTimeout = d
+	})
+}
+
+// Restart restart the ExecFunc of the unit according to the policy
+func Restart(policy RestartPolicy) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		if policy.Mode == RestartNever {
+			unit.restart = nil
+			return
+		}
+		unit.restart = newRestartTracker(policy)
+	})
+}
+
+// UseExitPolicy decide what to do with the application when the unit exits,
+// applied after the restart policy gave up
+func UseExitPolicy(policy ExitPolicy) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		unit.exitPolicy = policy
+	})
+}
+
+// Critical shut down the application once the unit exits
+func Critical() UnitOption {
+	return UseExitPolicy(ExitPolicyShutdown)
+}
+
+// UsePanicPolicy decide what to do when the ExecFunc of the unit panics,
+// override the DefaultPanicPolicy of the application
+func UsePanicPolicy(policy PanicPolicy) UnitOption {
+	return optionFunc[*unitImpl](func(unit *unitImpl) {
+		unit.panicPolicy = policy
+	})
+}
@@ -0,0 +1,64 @@
+package kboot
+
+import (
+	"fmt"
+)
+
+// PanicPolicy what to do when the ExecFunc of a unit panics
+type PanicPolicy int
+
+const (
+	// PanicPolicyDefault follow the application wide policy, see DefaultPanicPolicy
+	PanicPolicyDefault PanicPolicy = iota
+	// PanicPolicyRecover recover the panic as a bad ExitResult carrying a *PanicError,
+	// then apply the restart and exit policy of the unit, default
+	PanicPolicyRecover
+	// PanicPolicyCrash let the panic crash the process
+	PanicPolicyCrash
+)
+
+func (this PanicPolicy) String() string {
+	switch this {
+	case PanicPolicyDefault:
+		return "default"
+	case PanicPolicyRecover:
+		return "recover"
+	case PanicPolicyCrash:
+		return "crash"
+	default:
+		return "unknown"
+	}
+}
+
+// PanicError the error of the ExitResult of a recovered ExecFunc panic
+type PanicError struct {
+	// Unit the name of the unit
+	Unit string
+	// Value the value passed to panic
+	Value any
+	// Stack the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (this *PanicError) Error() string {
+	return fmt.Sprintf("unit '%s' exec panic: %v", this.Unit, this.Value)
+}
+
+// Unwrap return the panic value if it is an error
+func (this *PanicError) Unwrap() error {
+	if err, ok := this.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// panicPolicyOf the panic policy of the unit, fallback to the application wide policy
+func (this *_ctx) panicPolicyOf(unit *unitImpl) PanicPolicy {
+	if unit.panicPolicy != PanicPolicyDefault {
+		return unit.panicPolicy
+	}
+	if this.panicPolicy != PanicPolicyDefault {
+		return this.panicPolicy
+	}
+	return PanicPolicyRecover
+}
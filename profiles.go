@@ -0,0 +1,81 @@
+package kboot
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// resolveProfiles expand the profiles to activate from v,
+// the included profiles come first, then the active ones in declared order,
+// each profile is followed by the members of its group, a profile appearing twice keeps its first position
+func resolveProfiles(v *viper.Viper) []string {
+	ret := make([]string, 0)
+	var expand func(profile string)
+	expand = func(profile string) {
+		for _, p := range ret {
+			if strings.EqualFold(p, profile) {
+				return
+			}
+		}
+		ret = append(ret, profile)
+		for _, member := range profileList(v, fmt.Sprintf(CfgKeyProfilesGroup, profile)) {
+			expand(member)
+		}
+	}
+	for _, profile := range profileList(v, CfgKeyProfilesInclude) {
+		expand(profile)
+	}
+	for _, profile := range profileList(v, CfgKeyProfilesActive) {
+		expand(profile)
+	}
+	return ret
+}
+
+// newProfiles the profiles not applied yet, in order
+func newProfiles(applied, profiles []string) []string {
+	ret := make([]string, 0)
+	for _, profile := range profiles {
+		if !slices.ContainsFunc(applied, func(p string) bool {
+			return strings.EqualFold(p, profile)
+		}) {
+			ret = append(ret, profile)
+		}
+	}
+	return ret
+}
+
+// profileList read a list of profiles from a list or a comma separated string
+func profileList(v *viper.Viper, key string) []string {
+	var items []string
+	switch raw := v.Get(key).(type) {
+	case nil:
+		return nil
+	case string:
+		items = strings.Split(raw, ",")
+	default:
+		items = cast.ToStringSlice(raw)
+	}
+	ret := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// GetActivatedProfiles the expanded active profiles, later profiles take precedence
+func (this *_ctx) GetActivatedProfiles() []string {
+	this.viperLock.RLock()
+	defer this.viperLock.RUnlock()
+	return append([]string{}, this.profiles...)
+}
+
+// GetActivatedProfile the expanded active profiles joined by comma
+func (this *_ctx) GetActivatedProfile() string {
+	return strings.Join(this.GetActivatedProfiles(), ",")
+}
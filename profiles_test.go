@@ -0,0 +1,30 @@
+package kboot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestResolveProfiles(t *testing.T) {
+	v := viper.New()
+	v.Set(CfgKeyProfilesInclude, "base")
+	v.Set(CfgKeyProfilesActive, "prod, EU-West,, base")
+	v.Set("kboot.profiles.group.prod", []string{"proddb", "PROD"})
+	v.Set("kboot.profiles.group.eu-west", []interface{}{"eu_group"})
+	v.Set("kboot.profiles.group.eu_group", "base,metrics")
+	if got := strings.Join(resolveProfiles(v), ","); got != "base,prod,proddb,EU-West,eu_group,metrics" {
+		t.Fatalf("unexpected profiles %s", got)
+	}
+	if got := strings.Join(resolveProfiles(viper.New()), ","); got != "" {
+		t.Fatalf("expect no profile, got %s", got)
+	}
+}
+
+func TestNewProfiles(t *testing.T) {
+	got := newProfiles([]string{"base", "prod"}, []string{"Base", "prod", "eu-west", "proddb"})
+	if strings.Join(got, ",") != "eu-west,proddb" {
+		t.Fatalf("unexpected new profiles %v", got)
+	}
+}
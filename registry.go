@@ -0,0 +1,100 @@
+package kboot
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type serviceKey struct {
+	typ  reflect.Type
+	name string
+}
+
+func (this serviceKey) String() string {
+	if this.name == "" {
+		return this.typ.String()
+	}
+	return fmt.Sprintf("%s(%s)", this.typ, this.name)
+}
+
+type serviceEntry struct {
+	provider string
+	value    interface{}
+}
+
+// serviceRegistry the services provided by units
+type serviceRegistry struct {
+	lock     sync.RWMutex
+	services map[serviceKey]*serviceEntry
+}
+
+func newServiceRegistry() *serviceRegistry {
+	return &serviceRegistry{
+		services: make(map[serviceKey]*serviceEntry),
+	}
+}
+
+// Provide share value of type T with the units which depend on unit
+func Provide[T any](unit Unit, value T) error {
+	return ProvideNamed[T](unit, "", value)
+}
+
+// ProvideNamed same as Provide, name distinguishes services of the same type
+func ProvideNamed[T any](unit Unit, name string, value T) error {
+	impl, err := registryUnit(unit)
+	if err != nil {
+		return err
+	}
+	key := serviceKey{typ: reflect.TypeOf((*T)(nil)).Elem(), name: name}
+	registry := impl.rootCtx.services
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if exist, ok := registry.services[key]; ok {
+		return errors.Errorf("unit '%s' provide service %s, but it is already provided by unit '%s'",
+			impl.GetName(), key, exist.provider)
+	}
+	registry.services[key] = &serviceEntry{
+		provider: impl.GetName(),
+		value:    value,
+	}
+	return nil
+}
+
+// Lookup get the service of type T provided by the unit itself or one of its dependencies
+func Lookup[T any](unit Unit) (T, error) {
+	return LookupNamed[T](unit, "")
+}
+
+// LookupNamed same as Lookup, get the service provided by ProvideNamed
+func LookupNamed[T any](unit Unit, name string) (T, error) {
+	var zero T
+	impl, err := registryUnit(unit)
+	if err != nil {
+		return zero, err
+	}
+	key := serviceKey{typ: reflect.TypeOf((*T)(nil)).Elem(), name: name}
+	registry := impl.rootCtx.services
+	registry.lock.RLock()
+	entry, ok := registry.services[key]
+	registry.lock.RUnlock()
+	if !ok {
+		return zero, errors.Errorf("unit '%s' lookup service %s, but none of its dependencies %v provides it",
+			impl.GetName(), key, impl.depends)
+	}
+	if entry.provider != impl.GetName() && !impl.dependsOn(entry.provider) {
+		return zero, errors.Errorf("unit '%s' lookup service %s provided by unit '%s', but '%s' does not depend on '%s'",
+			impl.GetName(), key, entry.provider, impl.GetName(), entry.provider)
+	}
+	return entry.value.(T), nil
+}
+
+func registryUnit(unit Unit) (*unitImpl, error) {
+	impl, ok := unit.(*unitImpl)
+	if !ok || impl.rootCtx == nil {
+		return nil, errors.New("unit is not registered by kboot")
+	}
+	return impl, nil
+}
@@ -0,0 +1,119 @@
+package kboot
+
+import (
+	"time"
+)
+
+type RestartMode int
+
+const (
+	// RestartNever never restart the unit, default
+	RestartNever RestartMode = iota
+	// RestartOnFailure restart the unit when its ExecFunc returns a non-zero ExitResult or panics
+	RestartOnFailure
+	// RestartAlways restart the unit whenever its ExecFunc returns
+	RestartAlways
+)
+
+func (this RestartMode) String() string {
+	switch this {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	default:
+		return "unknown"
+	}
+}
+
+// RestartExhaustedAction the final action when the restart budget is exhausted
+type RestartExhaustedAction int
+
+const (
+	// RestartExhaustedStop leave the unit stopped, the application keeps running
+	RestartExhaustedStop RestartExhaustedAction = iota
+	// RestartExhaustedShutdown shut down the application
+	RestartExhaustedShutdown
+)
+
+const (
+	DefaultRestartInitialBackoff = time.Second
+	DefaultRestartMaxBackoff     = time.Minute
+)
+
+type RestartPolicy struct {
+	Mode RestartMode
+	// InitialBackoff the delay before the first restart, doubled on each restart within Window,
+	// default is DefaultRestartInitialBackoff
+	InitialBackoff time.Duration
+	// MaxBackoff the upper bound of the delay, default is DefaultRestartMaxBackoff
+	MaxBackoff time.Duration
+	// MaxRestarts the max restarts allowed within Window, <= 0 means no limit
+	MaxRestarts int
+	// Window the time window restarts are counted in, <= 0 means the whole process lifetime
+	Window time.Duration
+	// OnExhausted the action when MaxRestarts is reached
+	OnExhausted RestartExhaustedAction
+}
+
+// restartTracker track the restarts of a unit
+type restartTracker struct {
+	policy  RestartPolicy
+	history []time.Time
+}
+
+func newRestartTracker(policy RestartPolicy) *restartTracker {
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = DefaultRestartInitialBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = DefaultRestartMaxBackoff
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+	return &restartTracker{
+		policy:  policy,
+		history: make([]time.Time, 0),
+	}
+}
+
+// shouldRestart whether the policy wants a restart for the result
+func (this *restartTracker) shouldRestart(result ExitResult) bool {
+	switch this.policy.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return result.Code != 0
+	default:
+		return false
+	}
+}
+
+// next record a restart at now and return the backoff before it,
+// return false if the restart budget is exhausted
+func (this *restartTracker) next(now time.Time) (time.Duration, bool) {
+	if this.policy.Window > 0 {
+		kept := this.history[:0]
+		for _, at := range this.history {
+			if now.Sub(at) < this.policy.Window {
+				kept = append(kept, at)
+			}
+		}
+		this.history = kept
+	}
+	if this.policy.MaxRestarts > 0 && len(this.history) >= this.policy.MaxRestarts {
+		return 0, false
+	}
+	backoff := this.policy.InitialBackoff
+	for i := 0; i < len(this.history) && backoff < this.policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > this.policy.MaxBackoff {
+		backoff = this.policy.MaxBackoff
+	}
+	this.history = append(this.history, now)
+	return backoff, true
+}
@@ -0,0 +1,31 @@
+package kboot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartTracker_Next(t *testing.T) {
+	tracker := newRestartTracker(RestartPolicy{
+		Mode:           RestartOnFailure,
+		InitialBackoff: time.Second,
+		MaxBackoff:     3 * time.Second,
+		MaxRestarts:    3,
+		Window:         time.Minute,
+	})
+	now := time.Now()
+	for idx, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		backoff, ok := tracker.next(now)
+		if !ok || backoff != want {
+			t.Fatalf("restart %d: got %s %v, want %s", idx, backoff, ok, want)
+		}
+	}
+	if _, ok := tracker.next(now); ok {
+		t.Fatal("expect restart budget exhausted")
+	}
+	// restarts out of the window are forgotten
+	backoff, ok := tracker.next(now.Add(2 * time.Minute))
+	if !ok || backoff != time.Second {
+		t.Fatalf("got %s %v after window", backoff, ok)
+	}
+}
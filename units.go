@@ -2,9 +2,14 @@ package kboot
 
 import (
 	"context"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/guestin/log"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
 type (
@@ -14,6 +19,13 @@ type (
 		Depends(dep ...string)
 		// Done wait for  application exit
 		Done() <-chan struct{}
+		// MarkReady signal the unit is ready to serve, it is safe to call it more than once
+		MarkReady()
+		// Ready closed once the unit is ready,
+		// a unit without ExecFunc is ready as soon as it is initialized
+		Ready() <-chan struct{}
+		// RegisterHealthCheck register a check which affects the readiness of the application
+		RegisterHealthCheck(name string, fn HealthCheckFunc)
 	}
 
 	ExitResult struct {
@@ -22,23 +34,87 @@ type (
 	}
 	ExecFunc func(unit Unit) ExitResult
 	InitFunc func(unit Unit) (ExecFunc, error)
+	// StopFunc the bounded cleanup step of a unit, called on shutdown after its ExecFunc returned,
+	// ctx carries what is left of the stop deadline of the unit,
+	// skipped if the ExecFunc did not return within the deadline
+	StopFunc func(ctx context.Context) error
+	// StoppableInitFunc an InitFunc which may also return a StopFunc, see WithStop
+	StoppableInitFunc func(unit Unit) (ExecFunc, StopFunc, error)
+
+	// ExitPolicy what to do with the application when the unit exits
+	ExitPolicy int
+)
+
+const (
+	// ExitPolicyIgnore keep the application running, default
+	ExitPolicyIgnore ExitPolicy = iota
+	// ExitPolicyShutdownOnFailure shut down the application when the unit exits with a non-zero code
+	ExitPolicyShutdownOnFailure
+	// ExitPolicyShutdown shut down the application whenever the unit exits
+	ExitPolicyShutdown
 )
 
+// WithStop adapt fn to InitFunc, the returned StopFunc is called on shutdown
+// after the ExecFunc returned, with the stop deadline of the unit
+func WithStop(fn StoppableInitFunc) InitFunc {
+	return func(unit Unit) (ExecFunc, error) {
+		exeFunc, stopFunc, err := fn(unit)
+		if err != nil {
+			return nil, err
+		}
+		if impl, ok := unit.(*unitImpl); ok && !impl.setStopFunc(stopFunc) {
+			// the init timed out meanwhile, release what it opened
+			impl.stopAbandoned(stopFunc)
+		}
+		return exeFunc, nil
+	}
+}
+
 type unitImpl struct {
-	rootCtx    *_ctx
-	ctx        context.Context
-	name       string
-	initFunc   InitFunc
-	cancelFunc context.CancelFunc
-	exeFunc    ExecFunc
-	done       chan struct{}
-	closeOnce  uint32
-	logger     log.ClassicLog
-	zapLogger  log.ZapLog
-	depends    []string
+	rootCtx      *_ctx
+	ctx          context.Context
+	name         string
+	initFunc     InitFunc
+	cancelFunc   context.CancelFunc
+	exeFunc      ExecFunc
+	done         chan struct{}
+	closeOnce    uint32
+	logger       log.ClassicLog
+	zapLogger    log.ZapLog
+	depends      []string
+	ready        chan struct{}
+	readyOnce    sync.Once
+	config       *unitConfig
+	configValue  interface{}
+	conditions   []unitCondition
+	missingUnits []string
+	stopFunc     StopFunc
+	exitPolicy   ExitPolicy
+	panicPolicy  PanicPolicy
+	restart      *restartTracker
+	restarts     int32
+	state        int32
+	result       atomic.Pointer[ExitResult]
+	checks       []healthCheck
+	checksLock   sync.Mutex
+	// initCtx the context carrying the init deadline, returned by GetContext during init
+	initCtx      context.Context
+	initializing atomic.Bool
+	// initLock guard stopFunc and initAbandoned against an init still running after its timeout
+	initLock      sync.Mutex
+	initAbandoned bool
+	// initialized set once Init succeeded
+	initialized atomic.Bool
+	// initTimeout the max duration of the InitFunc
+	initTimeout time.Duration
+	// stopTimeout the max duration to wait for the unit to exit on shutdown
+	stopTimeout time.Duration
 }
 
 func (this *unitImpl) GetContext() context.Context {
+	if this.initializing.Load() {
+		return this.initCtx
+	}
 	return this.ctx
 }
 
@@ -53,8 +129,28 @@ func (this *unitImpl) Depends(dep ...string) {
 	this.depends = append(this.depends, dep...)
 }
 
+// dependsOn whether the unit declared the dependency
+func (this *unitImpl) dependsOn(name string) bool {
+	for _, dep := range this.depends {
+		if dep == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (this *unitImpl) Done() <-chan struct{} {
-	return this.ctx.Done()
+	return this.GetContext().Done()
+}
+
+func (this *unitImpl) MarkReady() {
+	this.readyOnce.Do(func() {
+		close(this.ready)
+	})
+}
+
+func (this *unitImpl) Ready() <-chan struct{} {
+	return this.ready
 }
 
 func (this *unitImpl) Wait() {
@@ -65,36 +161,282 @@ func (this *unitImpl) HasExecFunc() bool {
 	return this.exeFunc != nil
 }
 
+// Exec run the ExecFunc, the caller must record the result before calling exit
 func (this *unitImpl) Exec() ExitResult {
-	defer func() {
-		if this.done != nil && atomic.CompareAndSwapUint32(&this.closeOnce, 0, 1) {
-			close(this.done)
-		}
-	}()
 	if !this.HasExecFunc() {
 		<-this.ctx.Done()
 		return NewSuccessResult()
 	}
+	if this.restart == nil {
+		return this.execRecovered()
+	}
+	for {
+		result := this.execRecovered()
+		if this.ctx.Err() != nil || !this.restart.shouldRestart(result) {
+			return result
+		}
+		logger := this.rootCtx.logger.With(
+			log.UseSubTag(log.NewFixStyleText(this.GetName(), log.Red, true)))
+		backoff, ok := this.restart.next(time.Now())
+		if !ok {
+			logger.Error("restart budget exhausted",
+				zap.Int("restarts", this.RestartCount()),
+				zap.Int("code", result.Code), zap.Error(result.Error))
+			if this.restart.policy.OnExhausted == RestartExhaustedShutdown {
+				this.rootCtx.Shutdown(errors.Errorf("unit '%s' exhausted its restart budget", this.GetName()))
+			}
+			return result
+		}
+		logger.Warn("exit, restart later",
+			zap.Int("code", result.Code), zap.Error(result.Error),
+			zap.String("policy", this.restart.policy.Mode.String()),
+			zap.Duration("backoff", backoff))
+		this.setState(UnitStateRestarting)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-this.ctx.Done():
+			timer.Stop()
+			return result
+		case <-timer.C:
+		}
+		this.setState(UnitStateRunning)
+		restarts := atomic.AddInt32(&this.restarts, 1)
+		this.rootCtx.logger.With(
+			log.UseSubTag(log.NewFixStyleText(this.GetName(), log.Yellow, true))).
+			Info("restarting...", zap.Int32("restarts", restarts))
+	}
+}
+
+// execRecovered run the ExecFunc once, a panic is converted to a bad result carrying a *PanicError
+// unless the panic policy of the unit is PanicPolicyCrash
+func (this *unitImpl) execRecovered() (result ExitResult) {
+	if this.rootCtx.panicPolicyOf(this) == PanicPolicyCrash {
+		return this.exeFunc(this)
+	}
+	defer func() {
+		if exitPanic := recover(); exitPanic != nil {
+			panicErr := &PanicError{Unit: this.GetName(), Value: exitPanic, Stack: debug.Stack()}
+			this.rootCtx.logger.With(
+				log.UseSubTag(log.NewFixStyleText(this.GetName(), log.Red, true))).
+				Error("exec panic", zap.Any("panic", exitPanic), zap.ByteString("stack", panicErr.Stack))
+			result = NewBadResult(panicErr)
+		}
+	}()
 	return this.exeFunc(this)
 }
 
+func (this *unitImpl) RestartCount() int {
+	return int(atomic.LoadInt32(&this.restarts))
+}
+
+// exit mark the unit as exited
+func (this *unitImpl) exit() {
+	if this.done != nil && atomic.CompareAndSwapUint32(&this.closeOnce, 0, 1) {
+		close(this.done)
+	}
+}
+
+// waitDependsReady wait until all dependencies of the unit are ready,
+// timeout <= 0 means wait forever
+func (this *unitImpl) waitDependsReady(graph *unitGraph, timeout time.Duration) error {
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	for _, dep := range this.depends {
+		depUnit := graph.unit(dep)
+		select {
+		case <-depUnit.Ready():
+		case <-depUnit.done:
+			return errors.Errorf("unit '%s' dependency '%s' exited before ready", this.GetName(), dep)
+		case <-timeoutC:
+			return errors.Errorf("unit '%s' wait for dependency '%s' ready timeout after %s", this.GetName(), dep, timeout)
+		case <-this.ctx.Done():
+			return this.ctx.Err()
+		}
+	}
+	return nil
+}
+
 func (this *unitImpl) Cancel() {
 	this.cancelFunc()
 }
 
-func (this *unitImpl) Init(rootCtx *_ctx) error {
-	ctx, cancelFunc := context.WithCancel(rootCtx.ctx)
+// IsInitialized whether Init succeeded
+func (this *unitImpl) IsInitialized() bool {
+	return this.initialized.Load()
+}
+
+// initFailed mark the unit whose init failed as exited
+func (this *unitImpl) initFailed() {
+	this.Cancel()
+	this.exit()
+	this.setState(UnitStateExited)
+}
+
+// Stop cancel the unit and wait for its exit at most timeout,
+// timeout <= 0 means wait forever, return false if the deadline exceeded
+func (this *unitImpl) Stop(timeout time.Duration) bool {
+	this.Cancel()
+	if timeout <= 0 {
+		this.Wait()
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-this.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// callStopFunc call the StopFunc with a deadline of timeout,
+// timeout <= 0 means no deadline
+func (this *unitImpl) callStopFunc(timeout time.Duration) error {
+	return runStopFunc(context.WithoutCancel(this.ctx), this.getStopFunc(), timeout)
+}
+
+// runStopFunc call fn with a deadline of timeout, timeout <= 0 means no deadline
+func runStopFunc(ctx context.Context, fn StopFunc, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	errC := make(chan error, 1)
+	go func() {
+		defer func() {
+			if exitPanic := recover(); exitPanic != nil {
+				errC <- errors.Errorf("stop func panic: %v", exitPanic)
+			}
+		}()
+		errC <- fn(ctx)
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "stop func not returned within %s", timeout)
+	}
+}
+
+// Init init the unit, if timeout > 0 GetContext returns a context carrying the deadline during init
+// and an error is returned if the InitFunc does not return in time
+func (this *unitImpl) Init(rootCtx *_ctx, timeout time.Duration) error {
+	// units are cancelled one by one on shutdown rather than by the root context
+	ctx, cancelFunc := context.WithCancel(context.WithoutCancel(rootCtx.ctx))
+	this.setState(UnitStateInitializing)
 	this.rootCtx = rootCtx
 	this.ctx = ctx
 	this.cancelFunc = cancelFunc
 	this.logger = rootCtx.GetTaggedLogger(this.GetName())
 	this.zapLogger = rootCtx.GetTaggedZapLogger(this.GetName())
 	this.done = make(chan struct{})
-	exeFunc, err := this.initFunc(this)
+	if this.config != nil {
+		cfg, err := this.config.load(rootCtx.GetViper())
+		if err != nil {
+			this.initFailed()
+			return err
+		}
+		this.configValue = cfg
+	}
+	var exeFunc ExecFunc
+	var err error
+	if timeout > 0 {
+		exeFunc, err = this.initWithTimeout(ctx, timeout)
+	} else {
+		exeFunc, err = this.initFunc(this)
+	}
 	if err != nil {
-		this.Cancel()
+		this.initFailed()
 		return err
 	}
 	this.exeFunc = exeFunc
+	if !this.HasExecFunc() {
+		this.MarkReady()
+	}
+	this.initialized.Store(true)
 	return nil
 }
+
+// initResult the result of an InitFunc run with a timeout
+type initResult struct {
+	exeFunc ExecFunc
+	err     error
+}
+
+func (this *unitImpl) initWithTimeout(ctx context.Context, timeout time.Duration) (ExecFunc, error) {
+	initCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	this.initCtx = initCtx
+	this.initializing.Store(true)
+	defer this.initializing.Store(false)
+	resultC := make(chan initResult, 1)
+	go func() {
+		defer func() {
+			if exitPanic := recover(); exitPanic != nil {
+				resultC <- initResult{err: errors.Errorf("init panic: %v", exitPanic)}
+			}
+		}()
+		exeFunc, err := this.initFunc(this)
+		resultC <- initResult{exeFunc: exeFunc, err: err}
+	}()
+	select {
+	case r := <-resultC:
+		return r.exeFunc, r.err
+	case <-initCtx.Done():
+		this.initLock.Lock()
+		this.initAbandoned = true
+		this.initLock.Unlock()
+		go this.waitAbandonedInit(resultC)
+		return nil, errors.Errorf("init timed out after %s", timeout)
+	}
+}
+
+// waitAbandonedInit wait for the InitFunc which timed out and log its late result,
+// its ExecFunc is never run and its StopFunc is called at once, see WithStop
+func (this *unitImpl) waitAbandonedInit(resultC <-chan initResult) {
+	r := <-resultC
+	this.rootCtx.logger.With(
+		log.UseSubTag(log.NewFixStyleText(this.GetName(), log.Red, true))).
+		Warn("timed out init returned, result discarded", zap.Error(r.err))
+	// the StopFunc may be published right before the timeout fired,
+	// neither rollback nor stop calls it since the unit is not initialized
+	this.initLock.Lock()
+	fn := this.stopFunc
+	this.stopFunc = nil
+	this.initLock.Unlock()
+	this.stopAbandoned(fn)
+}
+
+// setStopFunc publish the StopFunc returned by the InitFunc, return false if the init already timed out
+func (this *unitImpl) setStopFunc(fn StopFunc) bool {
+	this.initLock.Lock()
+	defer this.initLock.Unlock()
+	if this.initAbandoned {
+		return false
+	}
+	this.stopFunc = fn
+	return true
+}
+
+func (this *unitImpl) getStopFunc() StopFunc {
+	this.initLock.Lock()
+	defer this.initLock.Unlock()
+	return this.stopFunc
+}
+
+// stopAbandoned call the StopFunc returned by an init which timed out
+func (this *unitImpl) stopAbandoned(fn StopFunc) {
+	if fn == nil {
+		return
+	}
+	err := runStopFunc(context.WithoutCancel(this.ctx), fn, this.rootCtx.stopTimeoutOf(this))
+	this.rootCtx.logger.With(
+		log.UseSubTag(log.NewFixStyleText(this.GetName(), log.Red, true))).
+		Warn("stop func of the timed out init called", zap.Error(err))
+}
@@ -0,0 +1,35 @@
+package kboot
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUnitImpl_WaitAbandonedInit(t *testing.T) {
+	ctx := newTestExecContext()
+	ctx.RegisterUnit("pool", func(unit Unit) (ExecFunc, error) {
+		return nil, nil
+	})
+	unit := ctx.units[0]
+	unit.rootCtx = ctx
+	unit.ctx = context.Background()
+	stopped := atomic.Bool{}
+	// the init published its StopFunc, then the timeout fired before its result was taken
+	if !unit.setStopFunc(func(ctx context.Context) error {
+		stopped.Store(true)
+		return nil
+	}) {
+		t.Fatal("expect the StopFunc published")
+	}
+	unit.initAbandoned = true
+	resultC := make(chan initResult, 1)
+	resultC <- initResult{}
+	unit.waitAbandonedInit(resultC)
+	if !stopped.Load() {
+		t.Fatal("expect the StopFunc of the abandoned init called")
+	}
+	if unit.getStopFunc() != nil {
+		t.Fatal("expect the StopFunc cleared")
+	}
+}